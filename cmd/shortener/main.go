@@ -2,9 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,13 +13,17 @@ import (
 	"time"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/policy"
 	grpcServer "github.com/kotche/url-shortening-service/internal/app/server/grpc"
 	restServer "github.com/kotche/url-shortening-service/internal/app/server/rest"
 	"github.com/kotche/url-shortening-service/internal/app/service"
 	"github.com/kotche/url-shortening-service/internal/app/storage"
+	"github.com/kotche/url-shortening-service/internal/app/storage/cache"
 	"github.com/kotche/url-shortening-service/internal/app/storage/postgres"
 	"github.com/kotche/url-shortening-service/internal/app/transport/grpc"
 	"github.com/kotche/url-shortening-service/internal/app/transport/rest"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
 )
 
 var (
@@ -31,55 +36,148 @@ func main() {
 
 	printBuildInfo()
 
-	rand.Seed(time.Now().UnixNano())
-
 	conf, err := config.NewConfig()
 	if err != nil {
 		log.Fatal(err.Error())
 		return
 	}
 
-	var (
-		URLStorage service.Storage
-		Database   service.Database
-		serviceURL *service.Service
-	)
+	if flag.Arg(0) == "migrate" {
+		if err = runMigrate(conf, flag.Arg(1)); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
 
-	if conf.DBConnect != "" {
-		Database, err = postgres.NewDB(conf.DBConnect)
+	keyRing, err := utils.LoadKeyRing(conf.SecretKeys, conf.SecretKeysFile, time.Second*config.CookieMaxAge)
+	if errors.Is(err, utils.ErrNoSigningKeys) {
+		log.Printf("WARNING: %s. The cookies are signed with an ephemeral key: they are invalidated on restart "+
+			"and not accepted by the other replicas", err)
+	} else if err != nil {
+		log.Fatal(err.Error())
+		return
+	} else {
+		utils.SetKeyRing(keyRing)
+	}
+
+	jwtAuth, err := utils.LoadJWTAuthenticator(conf.JWTKeys, conf.JWTKeysFile, conf.JWTUserIDClaim, conf.JWTIssuer, conf.JWTAudience)
+	if err != nil {
+		log.Fatal(err.Error())
+		return
+	}
+	if jwtAuth != nil {
+		utils.SetAuthenticator(jwtAuth)
+	}
+
+	quotas, err := utils.ParseQuotas(conf.URLQuotaOverrides)
+	if err != nil {
+		log.Fatal(err.Error())
+		return
+	}
+
+	var domainPolicy *policy.Engine
+	if conf.DomainRulesFile != "" || conf.BlocklistFile != "" {
+		domainPolicy, err = policy.NewEngine(conf.DomainRulesFile, conf.BlocklistFile)
 		if err != nil {
 			log.Fatal(err.Error())
 			return
 		}
-		serviceURL = service.NewService(Database)
-		serviceURL.SetDB(Database)
+		if conf.PolicyInterval > 0 {
+			domainPolicy.Watch(time.Second * time.Duration(conf.PolicyInterval))
+		}
+	}
 
-		serviceURL.RunWorker()
+	selfLinks, err := service.NewSelfLinks(conf.BaseURL, conf.SelfDomains, conf.SelfLinkMode, conf.SelfLinkMaxDepth)
+	if err != nil {
+		log.Fatal(err.Error())
+		return
+	}
 
-		defer func() {
-			err = Database.Close()
-			if err != nil {
-				log.Println(err.Error())
-			}
-		}()
+	if conf.DBConnect != "" && conf.URLMaxLength > config.URLMaxLen {
+		log.Fatalf("URL_MAX_LENGTH %d exceeds the width of the urls.origin column %d", conf.URLMaxLength, config.URLMaxLen)
+		return
+	}
+
+	var Database service.Database
+
+	if conf.DBConnect != "" {
+		Database, err = postgres.NewDB(conf.DBConnect)
 	} else if conf.FilePath != "" {
-		URLStorage, err = storage.NewFileStorage(conf.FilePath)
+		var fileStorage *storage.FileStorage
+		fileStorage, err = storage.NewFileStorage(conf.FilePath)
+		if err == nil && conf.CompactInterval > 0 {
+			fileStorage.RunCompaction(time.Second * time.Duration(conf.CompactInterval))
+		}
+		Database = fileStorage
+	} else {
+		Database = storage.NewUrls()
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+		return
+	}
+	defer func() {
+		err = Database.Close()
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}()
+
+	var nodeLease *service.NodeLease
+	if conf.Generator == service.GeneratorSnowflake && conf.NodeID < 0 {
+		nodeLease, err = service.LeaseNode(context.Background(), Database, time.Second*time.Duration(conf.NodeLeaseTTL))
 		if err != nil {
 			log.Fatal(err.Error())
 			return
 		}
-		serviceURL = service.NewService(URLStorage)
 		defer func() {
-			err = URLStorage.Close()
-			if err != nil {
+			if err := nodeLease.Release(context.Background()); err != nil {
+				log.Println(err.Error())
+			}
+		}()
+		conf.NodeID = int(nodeLease.Node)
+		log.Printf("leased node ID %d", conf.NodeID)
+	}
+
+	generator, err := service.NewGenerator(conf, Database)
+	if err != nil {
+		log.Fatal(err.Error())
+		return
+	}
+	if holder, ok := generator.(service.NodeHolder); ok && nodeLease != nil {
+		nodeLease.Run(holder)
+	}
+
+	var st service.Database = Database
+	if conf.CacheSize > 0 {
+		// the replicas sharing the database do not see the invalidations of each other: the new links
+		// must be found at once, the changed ones are stale for up to the TTL
+		st = cache.NewDatabase(Database, conf.CacheSize, time.Second*time.Duration(conf.CacheTTL), conf.DBConnect == "")
+	}
+
+	serviceURL := service.NewService(st)
+	serviceURL.SetDB(st)
+	serviceURL.Quotas = model.Quotas{Default: conf.URLQuota, Users: quotas}
+	serviceURL.Validator = service.NewURLValidator(conf.AllowedSchemes, conf.URLMaxLength, conf.StripURLFragment)
+	if domainPolicy != nil {
+		serviceURL.Policy = domainPolicy
+	}
+	serviceURL.SelfLinks = selfLinks
+	serviceURL.Gen = generator
+	if _, ok := generator.(model.Generator); ok && conf.KeyPoolSize > 0 {
+		pool := service.NewKeyPool(generator, Database, conf.KeyPoolSize)
+		pool.Run()
+		serviceURL.Pool = pool
+		defer func() {
+			if err := pool.Close(context.Background()); err != nil {
 				log.Println(err.Error())
 			}
 		}()
-	} else {
-		URLStorage = storage.NewUrls()
-		serviceURL = service.NewService(URLStorage)
 	}
 
+	serviceURL.RunWorker()
+	serviceURL.RunSweeper()
+
 	ctx, cansel := context.WithCancel(context.Background())
 	defer cansel()
 
@@ -115,6 +213,43 @@ func main() {
 	grpcSrv.Stop()
 }
 
+// runMigrate manages the database schema: shortener -d <dsn> migrate up|down|status
+func runMigrate(conf *config.Config, command string) error {
+	if conf.DBConnect == "" {
+		return fmt.Errorf("migrate: database DSN is not set")
+	}
+
+	migrator, err := postgres.NewMigrator(conf.DBConnect)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied at " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s: %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("migrate: unknown command %q, expected up, down or status", command)
+	}
+}
+
 // example: go run -ldflags "-X main.buildVersion=v1.0 -X 'main.buildDate=$(date +'%Y/%m/%d %H:%M:%S')'" main.go
 func printBuildInfo() {
 	fmt.Printf("Build version: %s\n", buildVersion)
@@ -11,21 +11,55 @@ import (
 
 // Config sets the basic settings
 type Config struct {
-	ServerAddr    string   `env:"SERVER_ADDRESS" envDefault:"localhost:8080" json:"server_address"`
-	BaseURL       string   `env:"BASE_URL" envDefault:"http://localhost:8080" json:"base_url"`
-	GRPCPort      string   `env:"GRPC_PORT" envDefault:"3200" json:"grpc_port"`
-	FilePath      string   `env:"FILE_STORAGE_PATH" json:"file_storage_path"`
-	DBConnect     string   `env:"DATABASE_DSN" json:"database_dsn"`
-	EnableHTTPS   bool     `env:"ENABLE_HTTPS" json:"enable_https"`
-	TrustedSubnet string   `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	HostWhitelist []string `json:"hostWhitelist"`
+	ServerAddr        string   `env:"SERVER_ADDRESS" envDefault:"localhost:8080" json:"server_address"`
+	BaseURL           string   `env:"BASE_URL" envDefault:"http://localhost:8080" json:"base_url"`
+	GRPCPort          string   `env:"GRPC_PORT" envDefault:"3200" json:"grpc_port"`
+	FilePath          string   `env:"FILE_STORAGE_PATH" json:"file_storage_path"`
+	DBConnect         string   `env:"DATABASE_DSN" json:"database_dsn"`
+	EnableHTTPS       bool     `env:"ENABLE_HTTPS" json:"enable_https"`
+	TrustedSubnet     string   `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	TrustedProxies    string   `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	HostWhitelist     []string `json:"hostWhitelist"`
+	CompactInterval   int      `env:"FILE_COMPACT_INTERVAL" envDefault:"3600" json:"file_compact_interval"`
+	SecretKeys        string   `env:"SECRET_KEYS" json:"secret_keys"`
+	SecretKeysFile    string   `env:"SECRET_KEYS_FILE" json:"secret_keys_file"`
+	JWTKeys           string   `env:"JWT_KEYS" json:"jwt_keys"`
+	JWTKeysFile       string   `env:"JWT_KEYS_FILE" json:"jwt_keys_file"`
+	JWTUserIDClaim    string   `env:"JWT_USER_ID_CLAIM" envDefault:"sub" json:"jwt_user_id_claim"`
+	JWTIssuer         string   `env:"JWT_ISSUER" json:"jwt_issuer"`
+	JWTAudience       string   `env:"JWT_AUDIENCE" json:"jwt_audience"`
+	WriteRateLimit    float64  `env:"WRITE_RATE_LIMIT" json:"write_rate_limit"`
+	WriteRateBurst    int      `env:"WRITE_RATE_BURST" json:"write_rate_burst"`
+	RedirectRateLimit float64  `env:"REDIRECT_RATE_LIMIT" json:"redirect_rate_limit"`
+	RedirectRateBurst int      `env:"REDIRECT_RATE_BURST" json:"redirect_rate_burst"`
+	URLQuota          int      `env:"URL_QUOTA" json:"url_quota"`
+	URLQuotaOverrides string   `env:"URL_QUOTA_OVERRIDES" json:"url_quota_overrides"`
+	AllowedSchemes    string   `env:"ALLOWED_SCHEMES" envDefault:"http,https" json:"allowed_schemes"`
+	URLMaxLength      int      `env:"URL_MAX_LENGTH" envDefault:"2048" json:"url_max_length"`
+	StripURLFragment  bool     `env:"STRIP_URL_FRAGMENT" json:"strip_url_fragment"`
+	DomainRulesFile   string   `env:"DOMAIN_RULES_FILE" json:"domain_rules_file"`
+	BlocklistFile     string   `env:"DOMAIN_BLOCKLIST_FILE" json:"domain_blocklist_file"`
+	PolicyInterval    int      `env:"POLICY_RELOAD_INTERVAL" envDefault:"30" json:"policy_reload_interval"`
+	SelfDomains       string   `env:"SELF_DOMAINS" json:"self_domains"`
+	SelfLinkMode      string   `env:"SELF_LINK_MODE" envDefault:"reject" json:"self_link_mode"`
+	SelfLinkMaxDepth  int      `env:"SELF_LINK_MAX_DEPTH" envDefault:"5" json:"self_link_max_depth"`
+	Generator         string   `env:"GENERATOR" envDefault:"random" json:"generator"`
+	HashKey           string   `env:"HASH_KEY" json:"hash_key"`
+	KeyPoolSize       int      `env:"KEY_POOL_SIZE" json:"key_pool_size"`
+	ShortURLLength    int      `env:"SHORT_URL_LENGTH" envDefault:"7" json:"short_url_length"`
+	ShortURLAlphabet  string   `env:"SHORT_URL_ALPHABET" envDefault:"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ" json:"short_url_alphabet"`
+	SequencePermute   bool     `env:"SEQUENCE_PERMUTE" json:"sequence_permute"`
+	NodeID            int      `env:"NODE_ID" envDefault:"-1" json:"node_id"`
+	NodeLeaseTTL      int      `env:"NODE_LEASE_TTL" envDefault:"60" json:"node_lease_ttl"`
+	CacheSize         int      `env:"CACHE_SIZE" json:"cache_size"`
+	CacheTTL          int      `env:"CACHE_TTL" envDefault:"5" json:"cache_ttl"`
 }
 
 // NewConfig priority: env and flag are on the same level, the configuration file is below
 // example config file flag: -c ./internal/app/config/config.json
 func NewConfig() (*Config, error) {
 	var (
-		serverAddr, baseURL, grpcPort, filePath, dbConnect, enableHTTPSStr, configFilePath, trustedSubnet string
+		serverAddr, baseURL, grpcPort, filePath, dbConnect, enableHTTPSStr, configFilePath, trustedSubnet, secretKeysFile string
 	)
 
 	regStringVar(&serverAddr, "a", serverAddr, "server address")
@@ -37,6 +71,7 @@ func NewConfig() (*Config, error) {
 	regStringVar(&configFilePath, "c", configFilePath, "config file")
 	regStringVar(&configFilePath, "config", configFilePath, "config file")
 	regStringVar(&trustedSubnet, "t", trustedSubnet, "trusted subnet")
+	regStringVar(&secretKeysFile, "k", secretKeysFile, "secret keys file")
 	flag.Parse()
 
 	conf := &Config{}
@@ -70,6 +105,9 @@ func NewConfig() (*Config, error) {
 	if trustedSubnet != "" {
 		conf.TrustedSubnet = trustedSubnet
 	}
+	if secretKeysFile != "" {
+		conf.SecretKeysFile = secretKeysFile
+	}
 
 	return conf, nil
 }
@@ -4,15 +4,31 @@ type ContextType string
 
 const (
 	ShortURLLen                  = 7
+	ShortURLAlphabet             = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	Compression                  = "gzip"
 	UserIDCookieName ContextType = "user_id"
 	CookieMaxAge                 = 86400
 	UserIDLen                    = 8
 	BufLen                       = 3
 	Timeout                      = 5
+	AliasMinLen                  = 3
+	AliasMaxLen                  = 50
+	SweepInterval                = 60
+	ClickChanLen                 = 1024
+	ClickBufLen                  = 100
+	DateLayout                   = "2006-01-02"
+	APIKeyPrefix                 = "sk_"
+	APIKeyLen                    = 32
+	APIKeyIDLen                  = 8
+	APIKeyNameMaxLen             = 100
+	APIKeyContext    ContextType = "api_key"
+	UsernameMinLen               = 3
+	UsernameMaxLen               = 50
+	PasswordMinLen               = 8
+	PasswordMaxLen               = 72
+	URLMaxLen                    = 2048 // the width of the urls.origin column
+	AllowedSchemes               = "http,https"
+	KeyedAttempts                = 10
+	SequenceBlock                = 100
+	KeyReserveTTL                = 86400 // the reservations of the crashed instances are reclaimed after it, seconds
 )
-
-// GetSecretKey returns the secret key for generating the encrypted user id in cookies
-func GetSecretKey() []byte {
-	return []byte("be55d1079e6c6167118ac91318fe")
-}
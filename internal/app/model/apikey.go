@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+// Scopes of the API keys
+const (
+	ScopeShorten = "shorten"
+	ScopeRead    = "read"
+	ScopeDelete  = "delete"
+	ScopeStats   = "stats"
+)
+
+// Scopes lists all scopes that can be granted to an API key
+var Scopes = []string{ScopeShorten, ScopeRead, ScopeDelete, ScopeStats}
+
+// APIKey is a long-lived key of a machine client. Only the hash of the key is stored
+type APIKey struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope checks whether the scope is granted to the key
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRevoked checks whether the key has been revoked
+func (k *APIKey) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
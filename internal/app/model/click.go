@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Click contains information about the redirect by the shortened URL
+type Click struct {
+	Short     string    `json:"short_url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// DayClicks contains the number of clicks per day
+type DayClicks struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+// ClickStats contains the click statistics of the shortened URL
+type ClickStats struct {
+	TotalClicks    int         `json:"total_clicks"`
+	UniqueVisitors int         `json:"unique_visitors"`
+	Days           []DayClicks `json:"days"`
+}
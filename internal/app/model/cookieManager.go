@@ -17,7 +17,10 @@ func (c CookieManager) GetUserID(r *http.Request) string {
 
 type CookieManagerMD struct{}
 
-// GetUserID returns the user ID from the metadata cookie
+// GetUserID returns the user ID authenticated by the bearer token or from the metadata cookie
 func (c CookieManagerMD) GetUserID(ctx context.Context) string {
+	if userID, ok := ctx.Value(config.UserIDCookieName).(string); ok {
+		return userID
+	}
 	return utils.GetUserIDFromMD(ctx)
 }
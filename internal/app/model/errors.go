@@ -19,3 +19,99 @@ type GoneError struct {
 func (e GoneError) Error() string {
 	return fmt.Sprintf("url %v gone", e.ShortenURL)
 }
+
+// ExpiredError called if the URL has expired
+type ExpiredError struct {
+	ShortenURL string
+}
+
+func (e ExpiredError) Error() string {
+	return fmt.Sprintf("url %v expired", e.ShortenURL)
+}
+
+// NotFoundError called if the URL does not exist or belongs to another user
+type NotFoundError struct {
+	ShortenURL string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("url %v not found", e.ShortenURL)
+}
+
+// ValidationError called if the input data did not pass validation
+type ValidationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("invalid %v: %v", e.Field, e.Reason)
+}
+
+// AliasConflictError called if the requested alias is already taken
+type AliasConflictError struct {
+	Alias string
+}
+
+func (e AliasConflictError) Error() string {
+	return fmt.Sprintf("alias %v is already taken", e.Alias)
+}
+
+// APIKeyNotFoundError called if the API key does not exist or belongs to another user
+type APIKeyNotFoundError struct {
+	ID string
+}
+
+func (e APIKeyNotFoundError) Error() string {
+	return fmt.Sprintf("api key %v not found", e.ID)
+}
+
+// InvalidAPIKeyError called if the API key is unknown or revoked
+type InvalidAPIKeyError struct{}
+
+func (e InvalidAPIKeyError) Error() string {
+	return "invalid api key"
+}
+
+// ScopeError called if the API key has no scope required for the operation
+type ScopeError struct {
+	Scope string
+}
+
+func (e ScopeError) Error() string {
+	return fmt.Sprintf("api key has no %v scope", e.Scope)
+}
+
+// UsernameTakenError called if the username is already registered
+type UsernameTakenError struct {
+	Username string
+}
+
+func (e UsernameTakenError) Error() string {
+	return fmt.Sprintf("username %v is already taken", e.Username)
+}
+
+// InvalidCredentialsError called if the username or the password is wrong
+type InvalidCredentialsError struct{}
+
+func (e InvalidCredentialsError) Error() string {
+	return "invalid username or password"
+}
+
+// QuotaExceededError called if the user's links would exceed the quota
+type QuotaExceededError struct {
+	Limit int
+}
+
+func (e QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota of %v links exceeded", e.Limit)
+}
+
+// BlockedDomainError called if the domain policy forbids the links to the domain
+type BlockedDomainError struct {
+	Domain string
+}
+
+func (e BlockedDomainError) Error() string {
+	return fmt.Sprintf("domain %v is blocked", e.Domain)
+}
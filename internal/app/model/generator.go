@@ -1,21 +1,240 @@
 package model
 
 import (
-	"math/rand"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
 )
 
-const symbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+// Generator generates shortened URL of the alphabet and the length. Zero values are the defaults of the config
+type Generator struct {
+	Alphabet string
+	Length   int
+}
+
+// NewGenerator checks the alphabet and the length: the alphabet is at least two distinct ASCII symbols
+func NewGenerator(alphabet string, length int) (Generator, error) {
+	if length < 1 {
+		return Generator{}, errors.New("the shortened URL length must be positive")
+	}
+	if len(alphabet) < 2 {
+		return Generator{}, errors.New("the alphabet must contain at least two symbols")
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for _, r := range alphabet {
+		if r >= 0x80 || r <= ' ' || r == '/' || r == '?' || r == '#' || r == '%' {
+			return Generator{}, errors.New("the alphabet must contain printable ASCII symbols allowed in the path")
+		}
+		if seen[r] {
+			return Generator{}, errors.New("the alphabet symbols must be distinct")
+		}
+		seen[r] = true
+	}
+	return Generator{Alphabet: alphabet, Length: length}, nil
+}
+
+func (g Generator) alphabet() string {
+	if g.Alphabet == "" {
+		return config.ShortURLAlphabet
+	}
+	return g.Alphabet
+}
 
-// Generator generates shortened RL
-type Generator struct{}
+func (g Generator) length() int {
+	if g.Length == 0 {
+		return config.ShortURLLen
+	}
+	return g.Length
+}
 
-// MakeShortURL returns the generated shortened URL
+// MakeShortURL returns the random shortened URL read from crypto/rand, so the codes can not be predicted.
+// It panics if the system source of randomness fails
 func (g Generator) MakeShortURL() string {
-	b := make([]byte, config.ShortURLLen)
-	for i := range b {
-		b[i] = symbols[rand.Intn(len(symbols))]
+	code, err := RandomString(g.alphabet(), g.length())
+	if err != nil {
+		panic(fmt.Sprintf("generator: %s", err))
+	}
+	return code
+}
+
+// RandomString returns n symbols of the alphabet read from crypto/rand. The alphabet is at most 256 symbols
+func RandomString(alphabet string, n int) (string, error) {
+	return sampleString(alphabet, n, rand.Reader)
+}
+
+// sampleString returns n symbols of the alphabet read from r. The bytes beyond the largest multiple
+// of the alphabet size are rejected, so every symbol is equally likely
+func sampleString(alphabet string, n int, r io.Reader) (string, error) {
+	limit := 256 - 256%len(alphabet)
+	b := make([]byte, 0, n)
+	buf := make([]byte, n+n/2+1)
+	for len(b) < n {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		for _, c := range buf {
+			if int(c) >= limit {
+				continue
+			}
+			b = append(b, alphabet[int(c)%len(alphabet)])
+			if len(b) == n {
+				break
+			}
+		}
+	}
+	return string(b), nil
+}
+
+// Encode returns the number in the alphabet, padded by the first symbol up to the length.
+// Numbers beyond the codes of the length get longer codes
+func (g Generator) Encode(n uint64) string {
+	alphabet := g.alphabet()
+	base := uint64(len(alphabet))
+
+	b := make([]byte, 0, g.length())
+	for n > 0 || len(b) < g.length() {
+		b = append(b, alphabet[n%base])
+		n /= base
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
 	}
 	return string(b)
 }
+
+// Space returns the number of codes of the length, false if it does not fit uint64
+func (g Generator) Space() (uint64, bool) {
+	base := uint64(len(g.alphabet()))
+	space := uint64(1)
+	for i := 0; i < g.length(); i++ {
+		hi, lo := bits.Mul64(space, base)
+		if hi != 0 {
+			return 0, false
+		}
+		space = lo
+	}
+	return space, true
+}
+
+// Permutation is a bijection of the numbers below the space of the codes. Consecutive numbers are mapped
+// to the codes that do not look consecutive. It obfuscates the order, it is not a cipher
+type Permutation struct {
+	gen        Generator
+	space      uint64
+	multiplier uint64
+}
+
+// NewPermutation creates the permutation of the codes of the generator
+func NewPermutation(g Generator) (*Permutation, error) {
+	space, ok := g.Space()
+	if !ok {
+		return nil, errors.New("too many codes of the length to permute")
+	}
+
+	// the golden ratio spreads the consecutive numbers over the space, coprime multiplier keeps the bijection
+	multiplier := uint64(float64(space) / math.Phi)
+	for multiplier > 1 && gcd(multiplier, space) != 1 {
+		multiplier--
+	}
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	return &Permutation{gen: g, space: space, multiplier: multiplier}, nil
+}
+
+// Permute maps the number, the numbers out of the space are returned as is
+func (p *Permutation) Permute(n uint64) uint64 {
+	if n >= p.space {
+		return n
+	}
+	n = p.mulMod(n)
+	n = p.reverse(n)
+	return p.mulMod(n)
+}
+
+func (p *Permutation) mulMod(n uint64) uint64 {
+	hi, lo := bits.Mul64(n, p.multiplier)
+	_, rem := bits.Div64(hi, lo, p.space)
+	return rem
+}
+
+// reverse reverses the digits of the number of the code length, so the last digits change the first symbols
+func (p *Permutation) reverse(n uint64) uint64 {
+	base := uint64(len(p.gen.alphabet()))
+	var r uint64
+	for i := 0; i < p.gen.length(); i++ {
+		r = r*base + n%base
+		n /= base
+	}
+	return r
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// HashGenerator derives the shortened URL from the keyed hash of the original URL and the user,
+// so the same link of the same user always gets the same code. MakeShortURL of the embedded Generator
+// returns a random code, it is used when the original URL is unknown
+type HashGenerator struct {
+	Generator
+	Key []byte
+}
+
+// MakeKeyedShortURL returns the code of the attempt. The next attempts give the other codes for the same input,
+// they resolve the collisions. Every symbol is drawn from the keyed stream of the input without a bias
+func (g HashGenerator) MakeKeyedShortURL(originURL string, userID string, attempt int) string {
+	msg := make([]byte, 0, len(originURL)+len(userID)+8)
+	msg = append(msg, originURL...)
+	msg = append(msg, 0)
+	msg = append(msg, userID...)
+	msg = append(msg, 0)
+	msg = strconv.AppendInt(msg, int64(attempt), 10)
+
+	code, err := sampleString(g.alphabet(), g.length(), &keyedStream{mac: hmac.New(sha256.New, g.Key), msg: msg})
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+// keyedStream reads the HMAC of the message and the block counter, block by block. It never ends
+type keyedStream struct {
+	mac     hash.Hash
+	msg     []byte
+	counter uint32
+	block   []byte
+}
+
+func (s *keyedStream) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.block) == 0 {
+			var counter [4]byte
+			binary.BigEndian.PutUint32(counter[:], s.counter)
+			s.counter++
+
+			s.mac.Reset()
+			s.mac.Write(s.msg)
+			s.mac.Write(counter[:])
+			s.block = s.mac.Sum(nil)
+		}
+		c := copy(p[n:], s.block)
+		s.block = s.block[c:]
+		n += c
+	}
+	return n, nil
+}
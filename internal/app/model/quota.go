@@ -0,0 +1,21 @@
+package model
+
+// Quotas limits the number of the live links of the users. Zero limit means no quota
+type Quotas struct {
+	Default int
+	Users   map[string]int
+}
+
+// Limit returns the quota of the user, the override takes precedence over the default one
+func (q Quotas) Limit(userID string) int {
+	if limit, ok := q.Users[userID]; ok {
+		return limit
+	}
+	return q.Default
+}
+
+// QuotaUsage contains the number of the live links of the user and the quota. Zero limit means no quota
+type QuotaUsage struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"`
+}
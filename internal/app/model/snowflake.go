@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The bits of the snowflake ID after the sign bit: the milliseconds since the epoch, the node and the sequence
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	MaxSnowflakeNode      = 1<<snowflakeNodeBits - 1
+	maxSnowflakeSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// ErrNodeNotHeld is returned while the node ID of the generator is not leased
+var ErrNodeNotHeld = errors.New("snowflake node ID is not held")
+
+// SnowflakeEpoch is the start of the time component of the snowflake IDs
+var SnowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator builds the codes from the time, the node ID and the sequence of the node.
+// The codes of the different nodes never collide, so the node IDs of the instances must be unique.
+// If the clock goes back or the sequence of the millisecond is over, the next milliseconds are borrowed,
+// so the generator never waits and never repeats itself while running.
+// The suspended generator gives no codes, see Suspend
+type SnowflakeGenerator struct {
+	Generator
+	node      int64
+	suspended bool
+	mu        sync.Mutex
+	last      int64
+	sequence  int64
+	now       func() time.Time
+}
+
+func NewSnowflakeGenerator(gen Generator, node int64) (*SnowflakeGenerator, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, fmt.Errorf("snowflake node ID must be from 0 to %d", MaxSnowflakeNode)
+	}
+	return &SnowflakeGenerator{Generator: gen, node: node, now: time.Now}, nil
+}
+
+// Suspend stops the generator, e.g. when the lease of the node ID is lost and another instance may take it
+func (g *SnowflakeGenerator) Suspend() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.suspended = true
+}
+
+// Resume starts the generator with the node ID that is held again
+func (g *SnowflakeGenerator) Resume(node int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.node, g.suspended = node, false
+}
+
+// Next returns the next ID of the node, ErrNodeNotHeld if the generator is suspended
+func (g *SnowflakeGenerator) Next() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.suspended {
+		return 0, ErrNodeNotHeld
+	}
+
+	ms := g.now().Sub(SnowflakeEpoch).Milliseconds()
+	switch {
+	case ms > g.last:
+		g.last, g.sequence = ms, 0
+	case g.sequence < maxSnowflakeSequence:
+		g.sequence++
+	default:
+		g.last, g.sequence = g.last+1, 0
+	}
+
+	return uint64(g.last)<<(snowflakeNodeBits+snowflakeSequenceBits) |
+		uint64(g.node)<<snowflakeSequenceBits | uint64(g.sequence), nil
+}
+
+// MakeShortURL returns the code of the next ID. It panics if the generator is suspended, the service
+// uses NextShortURL
+func (g *SnowflakeGenerator) MakeShortURL() string {
+	code, err := g.NextShortURL(context.Background())
+	if err != nil {
+		panic(fmt.Sprintf("generator: %s", err))
+	}
+	return code
+}
+
+// NextShortURL returns the code of the next ID, the codes are unique without checking them
+func (g *SnowflakeGenerator) NextShortURL(_ context.Context) (string, error) {
+	id, err := g.Next()
+	if err != nil {
+		return "", err
+	}
+	return g.Encode(id), nil
+}
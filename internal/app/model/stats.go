@@ -1,6 +1,14 @@
 package model
 
 type Stats struct {
-	NumberOfURLs  int `json:"urls"`
-	NumberOfUsers int `json:"users"`
+	NumberOfURLs  int         `json:"urls"`
+	NumberOfUsers int         `json:"users"`
+	Cache         *CacheStats `json:"cache,omitempty"`
+}
+
+// CacheStats contains the counters of the cache of the shortened URLs
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
 }
@@ -1,9 +1,12 @@
 package model
 
+import "time"
+
 // URL contains shortened and original URL
 type URL struct {
-	Short  string `json:"short_url"`
-	Origin string `json:"original_url"`
+	Short     string     `json:"short_url"`
+	Origin    string     `json:"original_url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 func NewURL(origin, short string) *URL {
@@ -12,10 +15,25 @@ func NewURL(origin, short string) *URL {
 	}
 }
 
+// IsExpired checks whether the link has expired at the specified time
+func (u *URL) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+// ShortenOptions contains optional parameters for shortening the URL
+type ShortenOptions struct {
+	Alias     string
+	ExpiresAt *time.Time
+	TTL       time.Duration
+}
+
 // InputCorrelationURL contains correlation ID and original URL.
 type InputCorrelationURL struct {
-	CorrelationID string `json:"correlation_id"`
-	Origin        string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	Origin        string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds    int64      `json:"ttl_seconds,omitempty"`
 }
 
 // OutputCorrelationURL contains correlation ID and shortened URL
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// User is a registered account. The account keeps the user ID, so the links are not lost with the cookie
+type User struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
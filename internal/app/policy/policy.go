@@ -0,0 +1,241 @@
+// Package policy decides which domains may be shortened and redirected to
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// Rules is the format of the rules file. The rules are exact domains or wildcards: *.example.com matches
+// the subdomains of example.com. The deny rules take precedence; if the allow list is not empty,
+// only the allowed domains pass
+type Rules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// domainSet matches the exact domains and the subdomains of the wildcard ones
+type domainSet struct {
+	exact    map[string]struct{}
+	wildcard map[string]struct{}
+}
+
+func newDomainSet() domainSet {
+	return domainSet{
+		exact:    make(map[string]struct{}),
+		wildcard: make(map[string]struct{}),
+	}
+}
+
+func (d domainSet) add(rule string) error {
+	rule = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(rule)), ".")
+	set := d.exact
+	if strings.HasPrefix(rule, "*.") {
+		rule, set = rule[2:], d.wildcard
+	}
+
+	domain, err := idna.Lookup.ToASCII(rule)
+	if err != nil || domain == "" {
+		return fmt.Errorf("invalid domain rule %q", rule)
+	}
+	set[domain] = struct{}{}
+	return nil
+}
+
+func (d domainSet) empty() bool {
+	return len(d.exact) == 0 && len(d.wildcard) == 0
+}
+
+func (d domainSet) contains(host string) bool {
+	if _, ok := d.exact[host]; ok {
+		return true
+	}
+	for parent := host; ; {
+		i := strings.IndexByte(parent, '.')
+		if i < 0 {
+			return false
+		}
+		parent = parent[i+1:]
+		if _, ok := d.wildcard[parent]; ok {
+			return true
+		}
+	}
+}
+
+// state is the immutable snapshot of the loaded files
+type state struct {
+	allow domainSet
+	deny  domainSet
+}
+
+// Engine checks the domains by the rules file and the hosts-format blocklist. Safe for concurrent use,
+// the files are reloaded by Watch without blocking the checks
+type Engine struct {
+	rulesFile     string
+	blocklistFile string
+
+	mu      sync.RWMutex
+	state   state
+	modTime map[string]time.Time
+}
+
+// NewEngine loads the rules file and the blocklist, either of them may be empty
+func NewEngine(rulesFile string, blocklistFile string) (*Engine, error) {
+	e := &Engine{
+		rulesFile:     rulesFile,
+		blocklistFile: blocklistFile,
+		modTime:       make(map[string]time.Time),
+	}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// IsBlocked checks the host of the URL, the host must be lowercased and in punycode
+func (e *Engine) IsBlocked(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.state.deny.contains(host) {
+		return true
+	}
+	return !e.state.allow.empty() && !e.state.allow.contains(host)
+}
+
+// Reload reads the files again. On error the current rules are kept
+func (e *Engine) Reload() error {
+	st := state{allow: newDomainSet(), deny: newDomainSet()}
+	modTime := make(map[string]time.Time)
+
+	if e.rulesFile != "" {
+		info, err := readFile(e.rulesFile, func(r io.Reader) error { return readRules(r, &st) })
+		if err != nil {
+			return err
+		}
+		modTime[e.rulesFile] = info
+	}
+	if e.blocklistFile != "" {
+		info, err := readFile(e.blocklistFile, func(r io.Reader) error { return readHosts(r, st.deny) })
+		if err != nil {
+			return err
+		}
+		modTime[e.blocklistFile] = info
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state = st
+	e.modTime = modTime
+	return nil
+}
+
+// Watch polls the modification time of the files and reloads them on change
+func (e *Engine) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !e.changed() {
+				continue
+			}
+			if err := e.Reload(); err != nil {
+				log.Printf("policy reload error: %s", err)
+				continue
+			}
+			log.Print("policy reloaded")
+		}
+	}()
+}
+
+func (e *Engine) changed() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, fileName := range []string{e.rulesFile, e.blocklistFile} {
+		if fileName == "" {
+			continue
+		}
+		info, err := os.Stat(fileName)
+		if err != nil {
+			log.Printf("policy stat error: %s", err)
+			continue
+		}
+		if !info.ModTime().Equal(e.modTime[fileName]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readFile opens the file, passes it to the reader and returns its modification time
+func readFile(fileName string, read func(r io.Reader) error) (time.Time, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err = read(file); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return info.ModTime(), nil
+}
+
+func readRules(r io.Reader, st *state) error {
+	var rules Rules
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return err
+	}
+	for _, rule := range rules.Allow {
+		if err := st.allow.add(rule); err != nil {
+			return err
+		}
+	}
+	for _, rule := range rules.Deny {
+		if err := st.deny.add(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHosts reads the hosts file format: the address followed by the domains, # starts a comment.
+// The address is ignored, every listed domain is blocked. Invalid domains are skipped
+func readHosts(r io.Reader, deny domainSet) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, domain := range fields {
+			if err := deny.add(domain); err != nil {
+				log.Printf("policy blocklist: %s", err)
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, fileName string, content string, modTime time.Time) {
+	require.NoError(t, os.WriteFile(fileName, []byte(content), 0o600))
+	require.NoError(t, os.Chtimes(fileName, modTime, modTime))
+}
+
+func TestEngineIsBlocked(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(dir, "rules.json")
+	blocklistFile := filepath.Join(dir, "hosts")
+	now := time.Now()
+
+	writeFile(t, rulesFile, `{"deny":["phish.com","*.evil.org","*.пример.рф"]}`, now)
+	writeFile(t, blocklistFile, `# malware domains
+0.0.0.0 malware.net  tracker.net # inline comment
+127.0.0.1	bad.example.com
+plain.example
+`, now)
+
+	e, err := NewEngine(rulesFile, blocklistFile)
+	require.NoError(t, err)
+
+	tests := []struct {
+		host    string
+		blocked bool
+	}{
+		{host: "example.com", blocked: false},
+		{host: "phish.com", blocked: true},
+		{host: "www.phish.com", blocked: false},
+		{host: "evil.org", blocked: false},
+		{host: "a.b.evil.org", blocked: true},
+		{host: "notevil.org", blocked: false},
+		{host: "www.xn--e1afmkfd.xn--p1ai", blocked: true},
+		{host: "malware.net", blocked: true},
+		{host: "tracker.net", blocked: true},
+		{host: "bad.example.com", blocked: true},
+		{host: "plain.example", blocked: true},
+		{host: "phish.com.", blocked: true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.blocked, e.IsBlocked(tt.host), tt.host)
+	}
+}
+
+func TestEngineAllowList(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	writeFile(t, rulesFile, `{"allow":["example.com","*.example.com"],"deny":["bad.example.com"]}`, time.Now())
+
+	e, err := NewEngine(rulesFile, "")
+	require.NoError(t, err)
+
+	assert.False(t, e.IsBlocked("example.com"))
+	assert.False(t, e.IsBlocked("www.example.com"))
+	assert.True(t, e.IsBlocked("bad.example.com"), "deny rules take precedence")
+	assert.True(t, e.IsBlocked("example.org"))
+}
+
+func TestEngineReload(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	now := time.Now()
+	writeFile(t, rulesFile, `{"deny":["phish.com"]}`, now)
+
+	e, err := NewEngine(rulesFile, "")
+	require.NoError(t, err)
+	assert.False(t, e.changed())
+	assert.False(t, e.IsBlocked("example.com"))
+
+	writeFile(t, rulesFile, `{"deny":["example.com"]}`, now.Add(time.Second))
+	assert.True(t, e.changed())
+	require.NoError(t, e.Reload())
+	assert.True(t, e.IsBlocked("example.com"))
+	assert.False(t, e.IsBlocked("phish.com"))
+
+	writeFile(t, rulesFile, `{"deny":[`, now.Add(2*time.Second))
+	assert.Error(t, e.Reload())
+	assert.True(t, e.IsBlocked("example.com"), "the current rules are kept on error")
+}
+
+func TestNewEngineInvalidRule(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	writeFile(t, rulesFile, `{"deny":["exa_mple..com"]}`, time.Now())
+
+	_, err := NewEngine(rulesFile, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+// Package ratelimit limits the request rate with the token bucket algorithm
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// cleanupEvery is the number of calls between the removals of the idle buckets
+const cleanupEvery = 1024
+
+// Limiter decides whether the next request of the keys is allowed. The request takes a token of every key
+// or, if any key is out of tokens, none of them and gets the time to wait.
+// The state is kept behind the interface, so a shared backend can replace the memory one
+type Limiter interface {
+	Allow(keys ...string) (bool, time.Duration)
+}
+
+// Limit is the rate of the tokens per second and the bucket size. Zero rate means no limit
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter keeps the buckets in RAM. Safe for concurrent use
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	limit   Limit
+	buckets map[string]*bucket
+	calls   int
+	now     func() time.Time
+}
+
+// NewMemoryLimiter creates the limiter, the burst is at least one token
+func NewMemoryLimiter(limit Limit) *MemoryLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &MemoryLimiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the buckets of all keys if each of them has one
+func (l *MemoryLimiter) Allow(keys ...string) (bool, time.Duration) {
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.calls++
+	if l.calls%cleanupEvery == 0 {
+		l.cleanup(now)
+	}
+
+	buckets := make([]*bucket, 0, len(keys))
+	var wait float64
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(l.limit.Burst), last: now}
+			l.buckets[key] = b
+		}
+
+		b.tokens = l.refill(b, now)
+		b.last = now
+		if b.tokens < 1 {
+			wait = math.Max(wait, (1-b.tokens)/l.limit.Rate)
+		}
+		buckets = append(buckets, b)
+	}
+
+	if wait > 0 {
+		return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// refill returns the tokens of the bucket at the time
+func (l *MemoryLimiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.limit.Rate
+	return math.Min(tokens, float64(l.limit.Burst))
+}
+
+// cleanup removes the full buckets, they are the same as the new ones
+func (l *MemoryLimiter) cleanup(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// AllowAll allows the request only if the limiter allows all keys, e.g. the user ID and the client IP.
+// A denied request takes no token of any key. Empty keys are skipped
+func AllowAll(l Limiter, keys ...string) (bool, time.Duration) {
+	nonEmpty := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key != "" {
+			nonEmpty = append(nonEmpty, key)
+		}
+	}
+	return l.Allow(nonEmpty...)
+}
+
+// UserKey and IPKey separate the keys of the users and the addresses in one limiter
+func UserKey(userID string) string {
+	if userID == "" {
+		return ""
+	}
+	return "user:" + userID
+}
+
+func IPKey(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	return "ip:" + ip
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewMemoryLimiter(Limit{Rate: 2, Burst: 3})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("key")
+		assert.True(t, ok, "burst request %d", i)
+	}
+
+	ok, wait := l.Allow("key")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = l.Allow("other")
+	assert.True(t, ok, "keys have separate buckets")
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("key")
+	assert.True(t, ok, "token refilled")
+	ok, _ = l.Allow("key")
+	assert.False(t, ok)
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ = l.Allow("key")
+		assert.True(t, ok, "bucket is not filled over the burst")
+	}
+	ok, _ = l.Allow("key")
+	assert.False(t, ok)
+}
+
+func TestMemoryLimiterNoLimit(t *testing.T) {
+	l := NewMemoryLimiter(Limit{})
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("key")
+		assert.True(t, ok)
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+	l := NewMemoryLimiter(Limit{Rate: 1, Burst: 1})
+
+	ok, _ := AllowAll(l, UserKey("user1"), IPKey("192.168.1.10"))
+	assert.True(t, ok)
+
+	ok, _ = AllowAll(l, UserKey("user2"), IPKey("192.168.1.10"))
+	assert.False(t, ok, "address limit")
+
+	ok, _ = AllowAll(l, UserKey(""), IPKey(""))
+	assert.True(t, ok, "empty keys are skipped")
+}
+
+func TestAllowAllDeniedTakesNoToken(t *testing.T) {
+	l := NewMemoryLimiter(Limit{Rate: 1, Burst: 1})
+
+	ok, _ := AllowAll(l, UserKey("user1"), IPKey("192.168.1.10"))
+	assert.True(t, ok)
+
+	ok, wait := AllowAll(l, UserKey("user2"), IPKey("192.168.1.10"))
+	assert.False(t, ok, "address limit")
+	assert.Greater(t, wait, time.Duration(0))
+
+	ok, _ = AllowAll(l, UserKey("user2"), IPKey("192.168.1.11"))
+	assert.True(t, ok, "the denied request kept the user token")
+}
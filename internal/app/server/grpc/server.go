@@ -6,6 +6,8 @@ import (
 	"net"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/ratelimit"
 	grpcHandler "github.com/kotche/url-shortening-service/internal/app/transport/grpc"
 	"github.com/kotche/url-shortening-service/internal/app/transport/grpc/interceptors"
 	pb "github.com/kotche/url-shortening-service/internal/app/transport/grpc/proto"
@@ -18,13 +20,69 @@ type Server struct {
 	grpcServer *grpc.Server
 }
 
+// internalMethods are available only from the trusted subnets
+var internalMethods = []string{
+	"/shortener.Shortener/HandleGetStats",
+}
+
+// apiKeyScopes are the scopes required from the API keys by the methods.
+// The API key management is not listed, so it is not available with the API keys
+var apiKeyScopes = map[string]string{
+	"/shortener.Shortener/Ping":                   "",
+	"/shortener.Shortener/HandleGet":              "",
+	"/shortener.Shortener/HandleGetStats":         "",
+	"/shortener.Shortener/HandlePost":             model.ScopeShorten,
+	"/shortener.Shortener/HandlePostShortenBatch": model.ScopeShorten,
+	"/shortener.Shortener/UpdateURL":              model.ScopeShorten,
+	"/shortener.Shortener/HandleGetUserURLs":      model.ScopeRead,
+	"/shortener.Shortener/GetQuota":               model.ScopeRead,
+	"/shortener.Shortener/HandleDeleteURLs":       model.ScopeDelete,
+	"/shortener.Shortener/HandleGetURLStats":      model.ScopeStats,
+}
+
+// writeMethods share the write limit, the redirect limit is used by HandleGet
+var writeMethods = []string{
+	"/shortener.Shortener/HandlePost",
+	"/shortener.Shortener/HandlePostShortenBatch",
+	"/shortener.Shortener/UpdateURL",
+	"/shortener.Shortener/HandleDeleteURLs",
+	"/shortener.Shortener/Register",
+	"/shortener.Shortener/Login",
+}
+
+// newLimiters creates the limiters of the methods
+func newLimiters(cfg *config.Config) map[string]ratelimit.Limiter {
+	writeLimiter := ratelimit.NewMemoryLimiter(ratelimit.Limit{Rate: cfg.WriteRateLimit, Burst: cfg.WriteRateBurst})
+	redirectLimiter := ratelimit.NewMemoryLimiter(ratelimit.Limit{Rate: cfg.RedirectRateLimit, Burst: cfg.RedirectRateBurst})
+
+	limiters := map[string]ratelimit.Limiter{
+		"/shortener.Shortener/HandleGet": redirectLimiter,
+	}
+	for _, method := range writeMethods {
+		limiters[method] = writeLimiter
+	}
+	return limiters
+}
+
 func NewServer(cfg *config.Config, handler *grpcHandler.Handler) *Server {
-	authInterceptor := grpc.UnaryInterceptor(interceptors.UnaryCookieInterceptor)
+	trustedNetwork := interceptors.NewTrustedNetwork(cfg, internalMethods...)
+	apiKeyAuth := interceptors.NewAPIKeyAuth(handler.Service, apiKeyScopes)
+	rateLimit := interceptors.NewRateLimit(trustedNetwork.TrustedProxies, newLimiters(cfg))
+	interceptorChain := grpc.ChainUnaryInterceptor(
+		trustedNetwork.UnaryTrustedNetworkInterceptor,
+		apiKeyAuth.UnaryAPIKeyInterceptor,
+		interceptors.UnaryBearerInterceptor,
+		interceptors.UnaryCookieInterceptor,
+		rateLimit.UnaryRateLimitInterceptor,
+	)
+	streamInterceptorChain := grpc.ChainStreamInterceptor(
+		interceptors.StreamCookieInterceptor,
+	)
 
 	return &Server{
 		cfg:        cfg,
 		handler:    handler,
-		grpcServer: grpc.NewServer(authInterceptor),
+		grpcServer: grpc.NewServer(interceptorChain, streamInterceptorChain),
 	}
 }
 
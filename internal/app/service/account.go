@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const usernameSymbols = "abcdefghijklmnopqrstuvwxyz0123456789-_."
+
+// dummyPasswordHash is compared when the user is not found, so the response time does not reveal the usernames
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// Register creates the account. The account takes the user ID of the current anonymous session,
+// so the links created before the registration stay with the user
+func (s *Service) Register(ctx context.Context, currentUserID string, username string, password string) (*model.User, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	username = strings.ToLower(strings.TrimSpace(username))
+	if err := validateCredentials(username, password); err != nil {
+		return nil, err
+	}
+
+	userID := currentUserID
+	registered, err := s.isRegistered(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if userID == "" || registered {
+		if userID, _, err = utils.MakeUserIDCookie(); err != nil {
+			return nil, err
+		}
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		ID:           userID,
+		Username:     username,
+		PasswordHash: string(passwordHash),
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+	if err = s.db.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login checks the password and returns the account. The links of the current anonymous session
+// are merged into the account if they fit into the account's quota. Otherwise, the login still succeeds
+// and the links stay with the anonymous session, as the links of the origins the account already has
+func (s *Service) Login(ctx context.Context, currentUserID string, username string, password string) (*model.User, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	username = strings.ToLower(strings.TrimSpace(username))
+	user, err := s.db.GetUser(ctx, username)
+	if err != nil {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, err
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, model.InvalidCredentialsError{}
+	}
+
+	if currentUserID == user.ID {
+		return user, nil
+	}
+	registered, err := s.isRegistered(ctx, currentUserID)
+	if err != nil {
+		return nil, err
+	}
+	if !registered {
+		n, err := s.mergeUserURLs(ctx, currentUserID, user.ID)
+		if errors.As(err, &model.QuotaExceededError{}) {
+			log.Printf("Login: links of the anonymous session not merged into the account %s: %v", user.Username, err)
+			return user, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			log.Printf("Login: %d links of the anonymous session merged into the account %s", n, user.Username)
+		}
+	}
+	return user, nil
+}
+
+func (s *Service) isRegistered(ctx context.Context, userID string) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+	return s.db.IsRegisteredUser(ctx, userID)
+}
+
+// validateCredentials checks the length and the character set of the username and the length of the password
+func validateCredentials(username string, password string) error {
+	if len(username) < config.UsernameMinLen || len(username) > config.UsernameMaxLen {
+		return model.ValidationError{
+			Field:  "username",
+			Reason: fmt.Sprintf("length must be between %d and %d", config.UsernameMinLen, config.UsernameMaxLen),
+		}
+	}
+	for _, r := range username {
+		if !strings.ContainsRune(usernameSymbols, r) {
+			return model.ValidationError{Field: "username", Reason: fmt.Sprintf("symbol %q is not allowed", r)}
+		}
+	}
+
+	if len(password) < config.PasswordMinLen || len(password) > config.PasswordMaxLen {
+		return model.ValidationError{
+			Field:  "password",
+			Reason: fmt.Sprintf("length must be between %d and %d", config.PasswordMinLen, config.PasswordMaxLen),
+		}
+	}
+	return nil
+}
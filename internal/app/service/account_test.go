@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginMergeQuota(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewUrls()
+	s := NewService(repo)
+	s.SetDB(repo)
+	s.Quotas = model.Quotas{Default: 2}
+
+	user, err := s.Register(ctx, "account", "alice", "password")
+	require.NoError(t, err)
+	require.NoError(t, repo.Add(ctx, "account", model.NewURL("https://example.com/1", "aaaaaaa")))
+	require.NoError(t, repo.Add(ctx, "anonymous1", model.NewURL("https://example.com/2", "bbbbbbb")))
+	require.NoError(t, repo.Add(ctx, "anonymous1", model.NewURL("https://example.com/3", "ccccccc")))
+	require.NoError(t, repo.Add(ctx, "anonymous2", model.NewURL("https://example.com/4", "ddddddd")))
+
+	got, err := s.Login(ctx, "anonymous1", "alice", "password")
+	require.NoError(t, err, "the login succeeds over the quota")
+	assert.Equal(t, user.ID, got.ID)
+
+	urls, err := repo.GetUserURLs(ctx, "account")
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	urls, err = repo.GetUserURLs(ctx, "anonymous1")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2, "the links over the quota stay with the anonymous session")
+
+	_, err = s.Login(ctx, "anonymous2", "alice", "password")
+	require.NoError(t, err)
+
+	urls, err = repo.GetUserURLs(ctx, "account")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+	urls, err = repo.GetUserURLs(ctx, "anonymous2")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
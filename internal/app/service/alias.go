@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+const aliasSymbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// reservedAliases contains the words that conflict with the service routes
+var reservedAliases = map[string]struct{}{
+	"api":   {},
+	"ping":  {},
+	"debug": {},
+}
+
+// validateAlias checks the length, the character set and the reserved words of the alias
+func validateAlias(alias string) error {
+	if len(alias) < config.AliasMinLen || len(alias) > config.AliasMaxLen {
+		return model.ValidationError{
+			Field:  "alias",
+			Reason: fmt.Sprintf("length must be between %d and %d", config.AliasMinLen, config.AliasMaxLen),
+		}
+	}
+
+	for _, r := range alias {
+		if !strings.ContainsRune(aliasSymbols, r) {
+			return model.ValidationError{Field: "alias", Reason: fmt.Sprintf("symbol %q is not allowed", r)}
+		}
+	}
+
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return model.ValidationError{Field: "alias", Reason: fmt.Sprintf("%q is a reserved word", alias)}
+	}
+
+	return nil
+}
+
+// checkAliasFree checks that the alias is not used by an existing or deleted link
+func (s *Service) checkAliasFree(ctx context.Context, alias string) error {
+	urlModel, err := s.st.GetByID(ctx, alias)
+	if urlModel != nil || errors.As(err, &model.GoneError{}) {
+		return model.AliasConflictError{Alias: alias}
+	}
+	return nil
+}
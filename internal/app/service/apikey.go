@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// CreateAPIKey issues a new API key for the user. The key itself is returned only once, the storage keeps its hash
+func (s *Service) CreateAPIKey(ctx context.Context, userID string, name string, scopes []string) (*model.APIKey, string, error) {
+	if s.db == nil {
+		return nil, "", fmt.Errorf("database not initialized")
+	}
+	if userID == "" {
+		return nil, "", fmt.Errorf("user ID is empty")
+	}
+	if len(name) > config.APIKeyNameMaxLen {
+		return nil, "", model.ValidationError{Field: "name", Reason: fmt.Sprintf("must be at most %d characters", config.APIKeyNameMaxLen)}
+	}
+	scopes, err := validateScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := randomHex(config.APIKeyIDLen)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(config.APIKeyLen)
+	if err != nil {
+		return nil, "", err
+	}
+	key := config.APIKeyPrefix + secret
+
+	apiKey := &model.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashAPIKey(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err = s.db.AddAPIKey(ctx, apiKey); err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+func (s *Service) GetAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	return s.db.GetAPIKeys(ctx, userID)
+}
+
+func (s *Service) RevokeAPIKey(ctx context.Context, userID string, id string) error {
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return s.db.RevokeAPIKey(ctx, userID, id, time.Now().UTC().Truncate(time.Second))
+}
+
+// AuthenticateAPIKey returns the active API key by its value
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string) (*model.APIKey, error) {
+	if s.db == nil || !strings.HasPrefix(key, config.APIKeyPrefix) {
+		return nil, model.InvalidAPIKeyError{}
+	}
+
+	apiKey, err := s.db.GetAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.IsRevoked() {
+		return nil, model.InvalidAPIKeyError{}
+	}
+	return apiKey, nil
+}
+
+// validateScopes checks that all scopes are known and removes duplicates
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, model.ValidationError{Field: "scopes", Reason: "at least one scope is required"}
+	}
+
+	result := make([]string, 0, len(scopes))
+	seen := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return nil, model.ValidationError{Field: "scopes", Reason: fmt.Sprintf("unknown scope %q", scope)}
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range model.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIKey returns the hash of the key for storage. The keys are random, so a fast hash is enough
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
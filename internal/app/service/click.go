@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// RecordClick queues the click for asynchronous saving, the click is dropped if the queue is full
+func (s *Service) RecordClick(click model.Click) {
+	if s.db == nil {
+		return
+	}
+
+	select {
+	case s.clickChan <- click:
+	default:
+		log.Printf("click queue is full, click on %s dropped", click.Short)
+	}
+}
+
+func (s *Service) GetClickStats(ctx context.Context, userID string, shortURL string) (*model.ClickStats, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	stats, err := s.db.GetClickStats(ctx, userID, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *Service) flushClicks(ctx context.Context, clicks []model.Click) {
+	go func() {
+		err := s.db.WriteClicks(ctx, clicks)
+		if err != nil {
+			log.Printf("error writing clicks: " + err.Error())
+		}
+	}()
+}
+
+// clickWorker accumulates clicks and writes them in batches by size or timeout
+func (s *Service) clickWorker() {
+	ctx := context.Background()
+	buf := make([]model.Click, 0, config.ClickBufLen)
+	ticker := time.NewTicker(time.Second * config.Timeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case click := <-s.clickChan:
+			buf = append(buf, click)
+			if len(buf) >= config.ClickBufLen {
+				s.flushClicks(ctx, buf)
+				buf = make([]model.Click, 0, config.ClickBufLen)
+			}
+		case <-ticker.C:
+			if len(buf) > 0 {
+				s.flushClicks(ctx, buf)
+				buf = make([]model.Click, 0, config.ClickBufLen)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// resolveExpiration returns the expiration time of the link from the absolute time or the TTL
+func resolveExpiration(expiresAt *time.Time, ttl time.Duration) (*time.Time, error) {
+	if expiresAt != nil && ttl != 0 {
+		return nil, model.ValidationError{Field: "expiration", Reason: "expires_at and ttl_seconds are mutually exclusive"}
+	}
+
+	now := time.Now()
+
+	if ttl < 0 {
+		return nil, model.ValidationError{Field: "ttl_seconds", Reason: "must be positive"}
+	} else if ttl > 0 {
+		t := now.Add(ttl)
+		return &t, nil
+	}
+
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, model.ValidationError{Field: "expires_at", Reason: "must be in the future"}
+	}
+
+	return expiresAt, nil
+}
+
+func (s *Service) RunSweeper() {
+	go s.sweeper()
+}
+
+// sweeper periodically marks expired links in the database
+func (s *Service) sweeper() {
+	ctx := context.Background()
+	ticker := time.NewTicker(time.Second * config.SweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.db.MarkExpired(ctx, time.Now())
+		if err != nil {
+			log.Printf("error marking expired: " + err.Error())
+			continue
+		}
+		if n > 0 {
+			log.Printf("marked %d expired urls", n)
+		}
+	}
+}
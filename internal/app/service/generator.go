@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// Generators selected by the configuration
+const (
+	GeneratorRandom    = "random"
+	GeneratorHash      = "hash"
+	GeneratorSequence  = "sequence"
+	GeneratorSnowflake = "snowflake"
+)
+
+// KeyedGenerator is implemented by the generators deriving the shortened URL from the original URL and the user.
+// The next attempt gives another code for the same input when the previous one is taken
+type KeyedGenerator interface {
+	MakeKeyedShortURL(originURL string, userID string, attempt int) string
+}
+
+// NewGenerator creates the generator selected by the configuration, the sequence generator takes the values
+// of the counter of the storage. The snowflake generator needs the node ID, see LeaseNode
+func NewGenerator(conf *config.Config, seq Sequencer) (IGenerator, error) {
+	gen, err := model.NewGenerator(conf.ShortURLAlphabet, conf.ShortURLLength)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.Generator {
+	case "", GeneratorRandom:
+		return gen, nil
+	case GeneratorHash:
+		if conf.HashKey == "" {
+			return nil, fmt.Errorf("generator %s: the hash key is not set", GeneratorHash)
+		}
+		return model.HashGenerator{Generator: gen, Key: []byte(conf.HashKey)}, nil
+	case GeneratorSequence:
+		return NewSequenceGenerator(gen, seq, conf.SequencePermute)
+	case GeneratorSnowflake:
+		return model.NewSnowflakeGenerator(gen, int64(conf.NodeID))
+	default:
+		return nil, fmt.Errorf("unknown generator %q", conf.Generator)
+	}
+}
+
+// addKeyed stores the URL under the first free code of the generator. If the user has already shortened
+// the URL, ConflictURLError with the stored code is returned like with the other generators, so the same input
+// always gets the same code. The code of a deleted link is taken, the link gets the next one
+func (s *Service) addKeyed(ctx context.Context, gen KeyedGenerator, userID string, urlModel *model.URL) (*model.URL, error) {
+	for attempt := 0; attempt < config.KeyedAttempts; attempt++ {
+		shortURL := gen.MakeKeyedShortURL(urlModel.Origin, userID, attempt)
+
+		stored, taken := s.findKeyed(ctx, shortURL)
+		if stored != nil && stored.Origin == urlModel.Origin {
+			return nil, model.ConflictURLError{ShortenURL: stored.Short}
+		}
+		if taken {
+			continue
+		}
+
+		urlModel.Short = shortURL
+		err := s.add(ctx, userID, urlModel)
+		if errors.As(err, &model.AliasConflictError{}) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return urlModel, nil
+	}
+	return nil, fmt.Errorf("no free shortened URL after %d attempts", config.KeyedAttempts)
+}
+
+// makeKeyedBatchURL is addKeyed for the batch: the new URL is added to the urls to write,
+// the codes of the batch are taken as well as the stored ones
+func (s *Service) makeKeyedBatchURL(ctx context.Context, gen KeyedGenerator, userID string, originURL string,
+	expiresAt *time.Time, urls map[string]*model.URL) (*model.URL, error) {
+
+	for attempt := 0; attempt < config.KeyedAttempts; attempt++ {
+		shortURL := gen.MakeKeyedShortURL(originURL, userID, attempt)
+
+		if urlModel, ok := urls[shortURL]; ok {
+			if urlModel.Origin == originURL {
+				return urlModel, nil
+			}
+			continue
+		}
+
+		stored, taken := s.findKeyed(ctx, shortURL)
+		if stored != nil && stored.Origin == originURL {
+			return stored, nil
+		}
+		if taken {
+			continue
+		}
+
+		urlModel := model.NewURL(originURL, shortURL)
+		urlModel.ExpiresAt = expiresAt
+		urls[shortURL] = urlModel
+		return urlModel, nil
+	}
+	return nil, fmt.Errorf("no free shortened URL after %d attempts", config.KeyedAttempts)
+}
+
+// findKeyed returns the live link stored under the code. Deleted and expired links keep the code taken
+func (s *Service) findKeyed(ctx context.Context, shortURL string) (*model.URL, bool) {
+	stored, err := s.st.GetByID(ctx, shortURL)
+	if err == nil && stored != nil {
+		return stored, true
+	}
+	return nil, errors.As(err, &model.GoneError{}) || errors.As(err, &model.ExpiredError{})
+}
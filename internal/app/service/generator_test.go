@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// attemptGenerator returns the same codes for any input: code0, code1 and so on
+type attemptGenerator struct {
+	model.Generator
+}
+
+func (g attemptGenerator) MakeKeyedShortURL(_ string, _ string, attempt int) string {
+	return "code" + strconv.Itoa(attempt)
+}
+
+func TestHashGenerator(t *testing.T) {
+	gen := model.HashGenerator{Key: []byte("secret")}
+
+	code := gen.MakeKeyedShortURL("https://example.com", "user1", 0)
+	assert.Len(t, code, config.ShortURLLen)
+	assert.Equal(t, code, gen.MakeKeyedShortURL("https://example.com", "user1", 0))
+	assert.NotEqual(t, code, gen.MakeKeyedShortURL("https://example.com", "user2", 0))
+	assert.NotEqual(t, code, gen.MakeKeyedShortURL("https://example.com", "user1", 1))
+	assert.NotEqual(t, code, model.HashGenerator{Key: []byte("other")}.MakeKeyedShortURL("https://example.com", "user1", 0))
+
+	long := model.HashGenerator{Generator: model.Generator{Alphabet: "ab", Length: 300}, Key: []byte("secret")}
+	code = long.MakeKeyedShortURL("https://example.com", "user1", 0)
+	assert.Len(t, code, 300)
+	assert.NotEqual(t, strings.Repeat("a", 50), code[250:], "the codes longer than the digest are not padded")
+	assert.InDelta(t, 150, strings.Count(code, "a"), 50, "the symbols are equally likely")
+}
+
+func TestKeyedGeneratorStorages(t *testing.T) {
+	fileStorage, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "storage.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	storages := map[string]Database{
+		"memory": storage.NewUrls(),
+		"file":   fileStorage,
+	}
+
+	for name, db := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewService(db)
+			s.SetDB(db)
+			s.Gen = model.HashGenerator{Key: []byte("secret")}
+
+			first, err := s.GetURLModel(ctx, "user1", "https://example.com")
+			require.NoError(t, err)
+			_, err = s.GetURLModel(ctx, "user1", "https://EXAMPLE.com")
+			assert.Equal(t, model.ConflictURLError{ShortenURL: first.Short}, err, "the stored link is a conflict")
+
+			another, err := s.GetURLModel(ctx, "user2", "https://example.com")
+			require.NoError(t, err)
+			assert.NotEqual(t, first.Short, another.Short)
+
+			batch, err := s.ShortenBatch(ctx, "user1", []model.InputCorrelationURL{
+				{CorrelationID: "1", Origin: "https://example.com"},
+				{CorrelationID: "2", Origin: "https://example.org"},
+				{CorrelationID: "3", Origin: "https://example.org"},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, first.Short, batch[0].Short, "the stored link is reused")
+			assert.Equal(t, batch[1].Short, batch[2].Short)
+
+			_, err = s.GetURLModel(ctx, "user1", "https://example.org")
+			assert.Equal(t, model.ConflictURLError{ShortenURL: batch[1].Short}, err)
+
+			require.NoError(t, db.DeleteBatch(ctx, []model.DeleteUserURLs{{UserID: "user1", Short: first.Short}}))
+			renewed, err := s.GetURLModel(ctx, "user1", "https://example.com")
+			require.NoError(t, err, "the deleted link does not block the new one")
+			assert.NotEqual(t, first.Short, renewed.Short)
+			_, err = db.GetByID(ctx, first.Short)
+			assert.ErrorAs(t, err, &model.GoneError{})
+		})
+	}
+}
+
+func TestKeyedGeneratorCollisions(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewUrls()
+	require.NoError(t, st.Add(ctx, "another", model.NewURL("https://example.com/taken", "code0")))
+	require.NoError(t, st.Add(ctx, "another", model.NewURL("https://example.com/deleted", "code1")))
+	require.NoError(t, st.DeleteBatch(ctx, []model.DeleteUserURLs{{UserID: "another", Short: "code1"}}))
+
+	s := NewService(st)
+	s.SetDB(st)
+	s.Gen = attemptGenerator{}
+
+	urlModel, err := s.GetURLModel(ctx, "user", "https://example.com/new")
+	require.NoError(t, err)
+	assert.Equal(t, "code2", urlModel.Short, "taken and deleted codes are skipped")
+
+	batch, err := s.ShortenBatch(ctx, "user", []model.InputCorrelationURL{
+		{CorrelationID: "1", Origin: "https://example.com/a"},
+		{CorrelationID: "2", Origin: "https://example.com/b"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "code3", batch[0].Short)
+	assert.Equal(t, "code4", batch[1].Short, "the codes of the batch are taken")
+}
+
+func TestGeneratorEncode(t *testing.T) {
+	gen, err := model.NewGenerator("0123456789", 3)
+	require.NoError(t, err)
+	assert.Equal(t, "000", gen.Encode(0))
+	assert.Equal(t, "042", gen.Encode(42))
+	assert.Equal(t, "1234", gen.Encode(1234), "the numbers beyond the length get longer codes")
+	assert.Len(t, gen.MakeShortURL(), 3)
+
+	for _, alphabet := range []string{"a", "aab", "ab/", "abц"} {
+		_, err = model.NewGenerator(alphabet, 3)
+		assert.Error(t, err, alphabet)
+	}
+	_, err = model.NewGenerator("ab", 0)
+	assert.Error(t, err)
+}
+
+func TestPermutation(t *testing.T) {
+	gen, err := model.NewGenerator("abc", 4)
+	require.NoError(t, err)
+	permutation, err := model.NewPermutation(gen)
+	require.NoError(t, err)
+
+	seen := make(map[uint64]bool)
+	for n := uint64(0); n < 81; n++ {
+		p := permutation.Permute(n)
+		assert.Less(t, p, uint64(81))
+		assert.False(t, seen[p], "the permutation is not a bijection")
+		seen[p] = true
+	}
+	assert.NotEqual(t, permutation.Permute(1)+1, permutation.Permute(2))
+	assert.Equal(t, uint64(100), permutation.Permute(100))
+
+	_, err = model.NewPermutation(model.Generator{Alphabet: "abcdefghijklmnopqrstuvwxyz0123456789", Length: 20})
+	assert.Error(t, err)
+}
+
+func TestSequenceGenerator(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+	fileStorage, err := storage.NewFileStorage(fileName)
+	require.NoError(t, err)
+
+	gen, err := NewSequenceGenerator(model.Generator{Alphabet: "0123456789", Length: 4}, fileStorage, false)
+	require.NoError(t, err)
+	s := NewService(fileStorage)
+	s.SetDB(fileStorage)
+	s.Gen = gen
+
+	urlModel, err := s.GetURLModel(ctx, "user1", "https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "0001", urlModel.Short)
+
+	batch, err := s.ShortenBatch(ctx, "user1", []model.InputCorrelationURL{
+		{CorrelationID: "1", Origin: "https://example.org"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0002", batch[0].Short)
+	require.NoError(t, fileStorage.Compact())
+	require.NoError(t, fileStorage.Close())
+
+	restored, err := storage.NewFileStorage(fileName)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	gen, err = NewSequenceGenerator(model.Generator{Alphabet: "0123456789", Length: 4}, restored, true)
+	require.NoError(t, err)
+	code, err := gen.NextShortURL(ctx)
+	require.NoError(t, err)
+	permutation, err := model.NewPermutation(model.Generator{Alphabet: "0123456789", Length: 4})
+	require.NoError(t, err)
+	assert.Equal(t, gen.Encode(permutation.Permute(config.SequenceBlock+1)), code,
+		"the values given out before the restart are skipped")
+}
+
+func TestRandomString(t *testing.T) {
+	const n = 30000
+	alphabet := "abc"
+
+	code, err := model.RandomString(alphabet, n)
+	require.NoError(t, err)
+	require.Len(t, code, n)
+
+	counts := make(map[rune]int)
+	for _, r := range code {
+		counts[r]++
+	}
+	require.Len(t, counts, len(alphabet))
+	for r, count := range counts {
+		assert.InDelta(t, n/len(alphabet), count, n/20, "the symbol %c is biased", r)
+	}
+
+	assert.NotEqual(t, model.Generator{}.MakeShortURL(), model.Generator{}.MakeShortURL())
+}
+
+func TestSnowflakeGenerator(t *testing.T) {
+	_, err := model.NewSnowflakeGenerator(model.Generator{}, model.MaxSnowflakeNode+1)
+	assert.Error(t, err)
+
+	seen := make(map[string]bool)
+	for _, node := range []int64{0, 1, model.MaxSnowflakeNode} {
+		gen, err := model.NewSnowflakeGenerator(model.Generator{}, node)
+		require.NoError(t, err)
+		for i := 0; i < 10000; i++ {
+			code := gen.MakeShortURL()
+			require.False(t, seen[code], "the code %s is repeated", code)
+			seen[code] = true
+		}
+	}
+}
+
+func TestSnowflakeGeneratorService(t *testing.T) {
+	ctx := context.Background()
+	st := &countingStorage{URLStorage: storage.NewUrls()}
+
+	lease, err := LeaseNode(ctx, st, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), lease.Node, "the local storage gets the only node")
+
+	gen, err := model.NewSnowflakeGenerator(model.Generator{}, lease.Node)
+	require.NoError(t, err)
+	s := NewService(st)
+	s.SetDB(st)
+	s.Gen = gen
+
+	first, err := s.GetURLModel(ctx, "user1", "https://example.com")
+	require.NoError(t, err)
+	second, err := s.GetURLModel(ctx, "user1", "https://example.org")
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Short, second.Short)
+	assert.Zero(t, st.lookups, "the snowflake codes are not looked up")
+	require.NoError(t, lease.Release(ctx))
+}
+
+// flakyLeaser leases the nodes in order and fails the calls while failing is set
+type flakyLeaser struct {
+	*storage.URLStorage
+	mu      sync.Mutex
+	failing bool
+	next    int64
+}
+
+func (l *flakyLeaser) setFailing(failing bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failing = failing
+}
+
+func (l *flakyLeaser) LeaseNode(_ context.Context, _ string, _ int64, _ time.Duration) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failing {
+		return 0, errors.New("storage is unavailable")
+	}
+	node := l.next
+	l.next++
+	return node, nil
+}
+
+func (l *flakyLeaser) RenewNode(_ context.Context, node int64, _ string, _ time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failing {
+		return fmt.Errorf("node %d is leased by another owner", node)
+	}
+	return nil
+}
+
+func (l *flakyLeaser) ReleaseNode(_ context.Context, _ int64, _ string) error {
+	return nil
+}
+
+func TestNodeLeaseRenewError(t *testing.T) {
+	ctx := context.Background()
+	leaser := &flakyLeaser{URLStorage: storage.NewUrls()}
+
+	lease, err := LeaseNode(ctx, leaser, 30*time.Millisecond)
+	require.NoError(t, err)
+	gen, err := model.NewSnowflakeGenerator(model.Generator{}, lease.Node)
+	require.NoError(t, err)
+	lease.Run(gen)
+	defer lease.Release(ctx)
+
+	_, err = gen.NextShortURL(ctx)
+	require.NoError(t, err)
+
+	leaser.setFailing(true)
+	assert.Eventually(t, func() bool {
+		_, err := gen.NextShortURL(ctx)
+		return errors.Is(err, model.ErrNodeNotHeld)
+	}, time.Second, 5*time.Millisecond, "the generator stops when the renewal fails")
+	assert.Panics(t, func() { gen.MakeShortURL() })
+
+	leaser.setFailing(false)
+	assert.Eventually(t, func() bool {
+		_, err := gen.NextShortURL(ctx)
+		return err == nil
+	}, time.Second, 5*time.Millisecond, "the generator resumes with a new lease")
+}
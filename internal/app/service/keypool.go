@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// ErrKeyPoolClosed is returned by the pool after Close
+var ErrKeyPoolClosed = errors.New("key pool is closed")
+
+// KeyReserver reserves the codes in the storage, every code is given out only once.
+// The storage drops the reservation when the code is used, the unused ones are released
+type KeyReserver interface {
+	ReserveKeys(ctx context.Context, keys []string) ([]string, error)
+	ReleaseKeys(ctx context.Context, keys []string) error
+}
+
+// KeyPool keeps the codes reserved in advance, so the new link is added without checking its code first.
+// The pool is refilled in the background when it runs below the half
+type KeyPool struct {
+	gen      IGenerator
+	reserver KeyReserver
+	keys     chan string
+	refill   chan struct{}
+	done     chan struct{}
+	closed   bool
+	mu       sync.Mutex
+}
+
+func NewKeyPool(gen IGenerator, reserver KeyReserver, size int) *KeyPool {
+	return &KeyPool{
+		gen:      gen,
+		reserver: reserver,
+		keys:     make(chan string, size),
+		refill:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run fills the pool and keeps refilling it in the background until Close
+func (p *KeyPool) Run() {
+	p.notify()
+	go func() {
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-p.refill:
+			}
+			if len(p.keys) > cap(p.keys)/2 {
+				continue
+			}
+			if err := p.fill(context.Background()); err != nil && !errors.Is(err, ErrKeyPoolClosed) {
+				log.Printf("key pool refill error: %s", err)
+			}
+		}
+	}()
+}
+
+// Close stops the refill and releases the codes left in the pool, so they do not stay reserved in the storage
+func (p *KeyPool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	keys := make([]string, 0, len(p.keys))
+	for len(p.keys) > 0 {
+		keys = append(keys, <-p.keys)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return p.reserver.ReleaseKeys(ctx, keys)
+}
+
+// Take returns a reserved code. If the pool is empty, it is filled in place
+func (p *KeyPool) Take(ctx context.Context) (string, error) {
+	for {
+		select {
+		case key := <-p.keys:
+			p.notify()
+			return key, nil
+		default:
+		}
+		if err := p.fill(ctx); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *KeyPool) notify() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// fill generates the codes up to the pool size and puts the reserved ones into the pool
+func (p *KeyPool) fill(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrKeyPoolClosed
+	}
+	n := cap(p.keys) - len(p.keys)
+	if n == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, n)
+	candidates := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		key := p.gen.MakeShortURL()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		candidates = append(candidates, key)
+	}
+
+	reserved, err := p.reserver.ReserveKeys(ctx, candidates)
+	if err != nil {
+		return err
+	}
+	if len(reserved) == 0 {
+		return errors.New("no free shortened URL to reserve")
+	}
+
+	for _, key := range reserved {
+		select {
+		case p.keys <- key:
+		default:
+		}
+	}
+	return nil
+}
+
+// Release returns the reservations of the codes taken from the pool but not used, e.g. when the write fails
+func (p *KeyPool) Release(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return p.reserver.ReleaseKeys(ctx, keys)
+}
+
+// addUnique stores the URL under a new code without looking it up first. A random code or a code taken
+// by a custom alias conflicts in the storage, then the next one is used
+func (s *Service) addUnique(ctx context.Context, userID string, urlModel *model.URL) (*model.URL, error) {
+	for attempt := 0; attempt < config.KeyedAttempts; attempt++ {
+		shortURL, err := s.makeShortURL(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		urlModel.Short = shortURL
+		err = s.add(ctx, userID, urlModel)
+		if err != nil {
+			s.releaseKeys(ctx, shortURL)
+		}
+		if errors.As(err, &model.AliasConflictError{}) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return urlModel, nil
+	}
+	return nil, fmt.Errorf("no free shortened URL after %d attempts", config.KeyedAttempts)
+}
+
+// releaseKeys releases the unused codes of the pool if any. The failure is only logged: the reservations
+// are reclaimed by the storage after the timeout anyway
+func (s *Service) releaseKeys(ctx context.Context, keys ...string) {
+	if s.Pool == nil {
+		return
+	}
+	if err := s.Pool.Release(ctx, keys...); err != nil {
+		log.Printf("release keys error: %s", err)
+	}
+}
+
+// makeShortURL returns a new code: from the pool or the unique generator if any, otherwise a random one
+func (s *Service) makeShortURL(ctx context.Context) (string, error) {
+	if s.Pool != nil {
+		return s.Pool.Take(ctx)
+	}
+	if gen, ok := s.Gen.(UniqueGenerator); ok {
+		return gen.NextShortURL(ctx)
+	}
+	return s.Gen.MakeShortURL(), nil
+}
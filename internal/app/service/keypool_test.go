@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sequenceGenerator returns key0, key1 and so on
+type sequenceGenerator struct {
+	next *int
+}
+
+func (g sequenceGenerator) MakeShortURL() string {
+	key := fmt.Sprintf("key%d", *g.next)
+	*g.next++
+	return key
+}
+
+// countingStorage counts the lookups of the codes
+type countingStorage struct {
+	*storage.URLStorage
+	lookups int
+}
+
+func (c *countingStorage) GetByID(ctx context.Context, id string) (*model.URL, error) {
+	c.lookups++
+	return c.URLStorage.GetByID(ctx, id)
+}
+
+func TestKeyPool(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewUrls()
+	pool := NewKeyPool(sequenceGenerator{next: new(int)}, st, 4)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		key, err := pool.Take(ctx)
+		require.NoError(t, err)
+		assert.False(t, seen[key], "the key %s is given out twice", key)
+		seen[key] = true
+	}
+
+	reserved, err := st.ReserveKeys(ctx, []string{"key0", "key100"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key100"}, reserved, "the pooled keys stay reserved")
+}
+
+func TestKeyPoolService(t *testing.T) {
+	ctx := context.Background()
+	st := &countingStorage{URLStorage: storage.NewUrls()}
+	s := NewService(st)
+	s.SetDB(st)
+	s.Pool = NewKeyPool(sequenceGenerator{next: new(int)}, st, 4)
+
+	_, err := s.GetURLModelWithOptions(ctx, "user1", "https://example.com/alias", model.ShortenOptions{Alias: "key0"})
+	require.NoError(t, err)
+	lookups := st.lookups
+
+	urlModel, err := s.GetURLModel(ctx, "user1", "https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "key1", urlModel.Short, "the key taken by the alias is skipped")
+
+	batch, err := s.ShortenBatch(ctx, "user1", []model.InputCorrelationURL{
+		{CorrelationID: "1", Origin: "https://example.org"},
+		{CorrelationID: "2", Origin: "https://example.net"},
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, batch[0].Short, batch[1].Short)
+	assert.Equal(t, lookups, st.lookups, "the pooled keys are not looked up")
+
+	for _, out := range batch {
+		stored, err := st.GetByID(ctx, out.Short)
+		require.NoError(t, err)
+		assert.NotNil(t, stored)
+	}
+}
+
+func TestKeyPoolClose(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewUrls()
+	pool := NewKeyPool(sequenceGenerator{next: new(int)}, st, 4)
+
+	key, err := pool.Take(ctx)
+	require.NoError(t, err)
+	require.NoError(t, st.Add(ctx, "user1", model.NewURL("https://example.com", key)))
+
+	require.NoError(t, pool.Close(ctx))
+
+	reserved, err := st.ReserveKeys(ctx, []string{"key0", "key1", "key2", "key3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key1", "key2", "key3"}, reserved, "the unused keys are released")
+
+	_, err = pool.Take(ctx)
+	assert.ErrorIs(t, err, ErrKeyPoolClosed)
+}
+
+func TestAddUniqueNoLookup(t *testing.T) {
+	ctx := context.Background()
+	st := &countingStorage{URLStorage: storage.NewUrls()}
+	s := NewService(st)
+	s.SetDB(st)
+
+	urlModel, err := s.GetURLModel(ctx, "user1", "https://example.com")
+	require.NoError(t, err)
+	assert.NotEmpty(t, urlModel.Short)
+	assert.Zero(t, st.lookups, "the random code is not looked up before the insert")
+}
+
+func TestKeyPoolReleaseOnError(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewUrls()
+	s := NewService(st)
+	s.SetDB(st)
+	s.Quotas = model.Quotas{Default: 1}
+	s.Pool = NewKeyPool(sequenceGenerator{next: new(int)}, st, 2)
+
+	_, err := s.GetURLModel(ctx, "user1", "https://example.com")
+	require.NoError(t, err)
+
+	_, err = s.GetURLModel(ctx, "user1", "https://example.org")
+	require.ErrorAs(t, err, &model.QuotaExceededError{})
+
+	reserved, err := st.ReserveKeys(ctx, []string{"key0", "key1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key1"}, reserved, "the key of the failed write is released")
+}
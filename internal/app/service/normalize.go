@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts are removed from the host, so that the same address is stored once
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URLValidator checks the original URLs before storage and brings them to the normalized form
+type URLValidator struct {
+	schemes       map[string]struct{}
+	maxLen        int
+	stripFragment bool
+}
+
+// NewURLValidator gets a comma-separated list of the allowed schemes and the maximum URL length.
+// If stripFragment is set, the fragment is removed from the URLs
+func NewURLValidator(schemes string, maxLen int, stripFragment bool) *URLValidator {
+	v := &URLValidator{
+		schemes:       make(map[string]struct{}),
+		maxLen:        maxLen,
+		stripFragment: stripFragment,
+	}
+	for _, scheme := range strings.Split(schemes, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "" {
+			v.schemes[scheme] = struct{}{}
+		}
+	}
+	return v
+}
+
+// Normalize validates the scheme, the host and the length of the URL. The host is lowercased and converted
+// to punycode, the default port is removed
+func (v *URLValidator) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", urlError("must not be empty")
+	}
+	if len(rawURL) > v.maxLen {
+		return "", urlError(fmt.Sprintf("must be at most %d characters", v.maxLen))
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", urlError("is not a valid URL")
+	}
+	if u.Scheme == "" {
+		return "", urlError("must be absolute, e.g. https://example.com")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := v.schemes[u.Scheme]; !ok {
+		return "", urlError(fmt.Sprintf("scheme %q is not allowed", u.Scheme))
+	}
+	if u.Opaque != "" || u.Hostname() == "" {
+		return "", urlError("must have a host")
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	u.Host = joinHostPort(host, port)
+
+	if v.stripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	normalized := u.String()
+	if len(normalized) > v.maxLen {
+		return "", urlError(fmt.Sprintf("must be at most %d characters", v.maxLen))
+	}
+	return normalized, nil
+}
+
+// normalizeHost lowercases the domain name and converts it to punycode. IP addresses are returned as is
+func normalizeHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return strings.ToLower(host), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.ToLower(host))
+	if err != nil {
+		return "", urlError(fmt.Sprintf("host %q is not valid", host))
+	}
+	return ascii, nil
+}
+
+func joinHostPort(host, port string) string {
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+func urlError(reason string) error {
+	return model.ValidationError{Field: "url", Reason: reason}
+}
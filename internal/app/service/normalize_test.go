@@ -0,0 +1,109 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLValidatorNormalize(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		stripFragment bool
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:  "already_normalized",
+			input: "https://example.com/path?q=1",
+			want:  "https://example.com/path?q=1",
+		},
+		{
+			name:  "whitespace_and_case",
+			input: "  HTTPS://WWW.Example.COM/Path  ",
+			want:  "https://www.example.com/Path",
+		},
+		{
+			name:  "default_port",
+			input: "http://example.com:80/a",
+			want:  "http://example.com/a",
+		},
+		{
+			name:  "other_port",
+			input: "https://example.com:8443/a",
+			want:  "https://example.com:8443/a",
+		},
+		{
+			name:  "idna",
+			input: "https://пример.рф/путь",
+			want:  "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C",
+		},
+		{
+			name:  "ipv6",
+			input: "http://[2001:DB8::1]:80/",
+			want:  "http://[2001:db8::1]/",
+		},
+		{
+			name:  "fragment_kept",
+			input: "https://example.com/a#top",
+			want:  "https://example.com/a#top",
+		},
+		{
+			name:          "fragment_stripped",
+			input:         "https://example.com/a#top",
+			stripFragment: true,
+			want:          "https://example.com/a",
+		},
+		{
+			name:    "empty",
+			input:   "   ",
+			wantErr: true,
+		},
+		{
+			name:    "relative",
+			input:   "/some/path",
+			wantErr: true,
+		},
+		{
+			name:    "without_scheme",
+			input:   "www.example.com",
+			wantErr: true,
+		},
+		{
+			name:    "javascript",
+			input:   "javascript:alert(1)",
+			wantErr: true,
+		},
+		{
+			name:    "without_host",
+			input:   "http:///path",
+			wantErr: true,
+		},
+		{
+			name:    "invalid_host",
+			input:   "https://exa_mple..com",
+			wantErr: true,
+		},
+		{
+			name:    "too_long",
+			input:   "https://example.com/" + strings.Repeat("a", 100),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewURLValidator("http, https", 100, tt.stripFragment)
+			got, err := v.Normalize(tt.input)
+			if tt.wantErr {
+				assert.ErrorAs(t, err, &model.ValidationError{})
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// checkDomain returns BlockedDomainError if the domain policy forbids the host of the URL
+func (s *Service) checkDomain(originURL string) error {
+	if s.Policy == nil {
+		return nil
+	}
+
+	var host string
+	if u, err := url.Parse(originURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	if s.Policy.IsBlocked(host) {
+		return model.BlockedDomainError{Domain: host}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// GetQuota returns the number of the user's live links and the user's quota
+func (s *Service) GetQuota(ctx context.Context, userID string) (model.QuotaUsage, error) {
+	if s.db == nil {
+		return model.QuotaUsage{}, fmt.Errorf("database not initialized")
+	}
+
+	used, err := s.db.CountUserURLs(ctx, userID, time.Now())
+	if err != nil {
+		return model.QuotaUsage{}, err
+	}
+	return model.QuotaUsage{Used: used, Limit: s.Quotas.Limit(userID)}, nil
+}
+
+// add stores the link. If the user has a quota, the storage checks it atomically with the write
+func (s *Service) add(ctx context.Context, userID string, urlModel *model.URL) error {
+	limit := s.Quotas.Limit(userID)
+	if limit <= 0 {
+		return s.st.Add(ctx, userID, urlModel)
+	}
+	if s.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return s.db.AddWithQuota(ctx, userID, urlModel, limit)
+}
+
+// mergeUserURLs moves the links of the anonymous session into the account if they fit into the account's quota
+func (s *Service) mergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	limit := s.Quotas.Limit(toUserID)
+	if limit <= 0 {
+		return s.db.MergeUserURLs(ctx, fromUserID, toUserID)
+	}
+	return s.db.MergeUserURLsWithQuota(ctx, fromUserID, toUserID, limit)
+}
+
+// writeBatch writes all links or none of them, e.g. if they exceed the user's quota
+func (s *Service) writeBatch(ctx context.Context, userID string, urls map[string]*model.URL) error {
+	limit := s.Quotas.Limit(userID)
+	if limit <= 0 {
+		return s.db.WriteBatch(ctx, userID, urls)
+	}
+	return s.db.WriteBatchWithQuota(ctx, userID, urls, limit)
+}
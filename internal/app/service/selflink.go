@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Self link modes: the origins pointing at the shortener are rejected or replaced with the final target
+const (
+	SelfLinkReject  = "reject"
+	SelfLinkResolve = "resolve"
+)
+
+// selfBase is the address the short links of the service are served from
+type selfBase struct {
+	host string
+	path string
+}
+
+// SelfLinks detects the origins pointing at the service itself: the base URL and the alias domains
+type SelfLinks struct {
+	bases    []selfBase
+	mode     string
+	maxDepth int
+}
+
+// NewSelfLinks gets the base URL, a comma-separated list of the alias domains or URLs, the mode
+// and the maximum length of the resolved chain
+func NewSelfLinks(baseURL string, aliases string, mode string, maxDepth int) (*SelfLinks, error) {
+	if mode != SelfLinkReject && mode != SelfLinkResolve {
+		return nil, fmt.Errorf("unknown self link mode %q, expected %s or %s", mode, SelfLinkReject, SelfLinkResolve)
+	}
+
+	l := &SelfLinks{mode: mode, maxDepth: maxDepth}
+	for _, base := range append([]string{baseURL}, strings.Split(aliases, ",")...) {
+		base = strings.TrimSpace(base)
+		if base == "" {
+			continue
+		}
+		if !strings.Contains(base, "://") {
+			base = "http://" + base
+		}
+		u, err := url.Parse(base)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid self link base %q", base)
+		}
+		l.bases = append(l.bases, selfBase{host: hostKey(u), path: strings.TrimSuffix(u.Path, "/")})
+	}
+	return l, nil
+}
+
+// match checks whether the URL points at the service. If it is a short link, its ID is returned
+func (l *SelfLinks) match(originURL string) (string, bool) {
+	u, err := url.Parse(originURL)
+	if err != nil {
+		return "", false
+	}
+	host := hostKey(u)
+
+	for _, base := range l.bases {
+		if base.host != host {
+			continue
+		}
+		if !strings.HasPrefix(u.Path, base.path+"/") && u.Path != base.path {
+			continue
+		}
+		id := strings.TrimPrefix(u.Path, base.path+"/")
+		if id == u.Path || strings.Contains(id, "/") {
+			id = ""
+		}
+		return id, true
+	}
+	return "", false
+}
+
+// resolveSelfLink rejects the origin pointing at the service or follows the chain of the short links
+// to the final target. The depth limit makes the cycles impossible
+func (s *Service) resolveSelfLink(ctx context.Context, originURL string) (string, error) {
+	if s.SelfLinks == nil {
+		return originURL, nil
+	}
+
+	for depth := 0; ; depth++ {
+		id, ok := s.SelfLinks.match(originURL)
+		if !ok {
+			return originURL, nil
+		}
+		if s.SelfLinks.mode == SelfLinkReject {
+			return "", urlError("must not point to the shortener")
+		}
+		if id == "" {
+			return "", urlError("must point to a short link of the shortener")
+		}
+		if depth >= s.SelfLinks.maxDepth {
+			return "", urlError(fmt.Sprintf("chain of the short links is longer than %d", s.SelfLinks.maxDepth))
+		}
+
+		urlModel, err := s.GetURLModelByID(ctx, id)
+		if err != nil {
+			return "", urlError(fmt.Sprintf("short link %s is not available", id))
+		}
+		originURL = urlModel.Origin
+	}
+}
+
+// hostKey returns the lowercased punycode host with the port if it is not the default one
+func hostKey(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+
+	port := u.Port()
+	if port == "" || port == defaultPorts[strings.ToLower(u.Scheme)] {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
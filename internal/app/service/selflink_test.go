@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfLinksReject(t *testing.T) {
+	s := NewService(storage.NewUrls())
+	selfLinks, err := NewSelfLinks("http://localhost:8080", "sho.rt, https://go.example.com/s", SelfLinkReject, 5)
+	require.NoError(t, err)
+	s.SelfLinks = selfLinks
+
+	ctx := context.Background()
+	for _, origin := range []string{
+		"http://localhost:8080/abcdefg",
+		"HTTP://LOCALHOST:8080/abcdefg",
+		"https://sho.rt/abcdefg",
+		"https://go.example.com/s/abcdefg",
+	} {
+		_, err = s.GetURLModel(ctx, "user", origin)
+		assert.ErrorAs(t, err, &model.ValidationError{}, origin)
+	}
+
+	for _, origin := range []string{
+		"http://localhost:8081/abcdefg",
+		"https://go.example.com/abcdefg",
+		"https://example.com",
+	} {
+		_, err = s.GetURLModel(ctx, "user", origin)
+		assert.NoError(t, err, origin)
+	}
+}
+
+func TestSelfLinksResolve(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewUrls()
+	require.NoError(t, st.Add(ctx, "user", model.NewURL("https://example.com/final", "aaaaaaa")))
+	require.NoError(t, st.Add(ctx, "user", model.NewURL("http://localhost:8080/aaaaaaa", "bbbbbbb")))
+	require.NoError(t, st.Add(ctx, "user", model.NewURL("http://localhost:8080/ccccccc", "ccccccc")))
+
+	s := NewService(st)
+	selfLinks, err := NewSelfLinks("http://localhost:8080", "", SelfLinkResolve, 5)
+	require.NoError(t, err)
+	s.SelfLinks = selfLinks
+
+	urlModel, err := s.GetURLModel(ctx, "another", "http://localhost:8080/bbbbbbb")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/final", urlModel.Origin)
+
+	_, err = s.GetURLModel(ctx, "user", "http://localhost:8080/bbbbbbb")
+	assert.Equal(t, model.ConflictURLError{ShortenURL: "aaaaaaa"}, err, "the user already has the resolved link")
+
+	for _, origin := range []string{
+		"http://localhost:8080/ccccccc",
+		"http://localhost:8080/unknown",
+		"http://localhost:8080/api/user/urls",
+		"http://localhost:8080",
+	} {
+		_, err = s.GetURLModel(ctx, "user", origin)
+		assert.ErrorAs(t, err, &model.ValidationError{}, origin)
+	}
+}
+
+func TestNewSelfLinksUnknownMode(t *testing.T) {
+	_, err := NewSelfLinks("http://localhost:8080", "", "follow", 5)
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// Sequencer gives out the values of the counter of the storage, every value only once
+type Sequencer interface {
+	NextSequence(ctx context.Context, n int) ([]uint64, error)
+}
+
+// UniqueGenerator is implemented by the generators whose codes are unique by construction,
+// they are added without checking the code first
+type UniqueGenerator interface {
+	NextShortURL(ctx context.Context) (string, error)
+}
+
+// SequenceGenerator encodes the counter of the storage into the alphabet. The values are taken
+// from the storage by blocks, the optional permutation hides the order of the codes
+type SequenceGenerator struct {
+	model.Generator
+	seq         Sequencer
+	permutation *model.Permutation
+	mu          sync.Mutex
+	values      []uint64
+}
+
+func NewSequenceGenerator(gen model.Generator, seq Sequencer, permute bool) (*SequenceGenerator, error) {
+	g := &SequenceGenerator{Generator: gen, seq: seq}
+	if permute {
+		permutation, err := model.NewPermutation(gen)
+		if err != nil {
+			return nil, err
+		}
+		g.permutation = permutation
+	}
+	return g, nil
+}
+
+// NextShortURL returns the code of the next value of the counter
+func (g *SequenceGenerator) NextShortURL(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.values) == 0 {
+		values, err := g.seq.NextSequence(ctx, config.SequenceBlock)
+		if err != nil {
+			return "", err
+		}
+		g.values = values
+	}
+
+	value := g.values[0]
+	g.values = g.values[1:]
+	if g.permutation != nil {
+		value = g.permutation.Permute(value)
+	}
+	return g.Encode(value), nil
+}
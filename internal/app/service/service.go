@@ -16,6 +16,7 @@ type Storage interface {
 	Add(ctx context.Context, userID string, url *model.URL) error
 	GetByID(ctx context.Context, id string) (*model.URL, error)
 	GetUserURLs(ctx context.Context, userID string) ([]*model.URL, error)
+	Update(ctx context.Context, userID string, url *model.URL) error
 	Close() error
 }
 
@@ -27,6 +28,24 @@ type Database interface {
 	DeleteBatch(ctx context.Context, toDelete []model.DeleteUserURLs) error
 	GetNumberOfUsers(ctx context.Context) (int, error)
 	GetNumberOfURLs(ctx context.Context) (int, error)
+	MarkExpired(ctx context.Context, now time.Time) (int, error)
+	WriteClicks(ctx context.Context, clicks []model.Click) error
+	GetClickStats(ctx context.Context, userID string, shortURL string) (*model.ClickStats, error)
+	AddAPIKey(ctx context.Context, key *model.APIKey) error
+	GetAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID string, id string, revokedAt time.Time) error
+	CreateUser(ctx context.Context, user *model.User) error
+	GetUser(ctx context.Context, username string) (*model.User, error)
+	IsRegisteredUser(ctx context.Context, userID string) (bool, error)
+	MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error)
+	MergeUserURLsWithQuota(ctx context.Context, fromUserID string, toUserID string, limit int) (int, error)
+	CountUserURLs(ctx context.Context, userID string, now time.Time) (int, error)
+	AddWithQuota(ctx context.Context, userID string, url *model.URL, limit int) error
+	WriteBatchWithQuota(ctx context.Context, userID string, urls map[string]*model.URL, limit int) error
+	ReserveKeys(ctx context.Context, keys []string) ([]string, error)
+	ReleaseKeys(ctx context.Context, keys []string) error
+	NextSequence(ctx context.Context, n int) ([]uint64, error)
 }
 
 // IGenerator describes methods for generating shortened links
@@ -34,11 +53,27 @@ type IGenerator interface {
 	MakeShortURL() string
 }
 
+// ICacheStats is implemented by the caching storages
+type ICacheStats interface {
+	CacheStats() model.CacheStats
+}
+
+// IDomainPolicy decides whether the links to the domain are forbidden
+type IDomainPolicy interface {
+	IsBlocked(host string) bool
+}
+
 type Service struct {
 	st           Storage
 	db           Database
 	Gen          IGenerator
+	Validator    *URLValidator
+	Policy       IDomainPolicy
+	SelfLinks    *SelfLinks
+	Pool         *KeyPool
+	Quotas       model.Quotas
 	deletionChan chan model.DeleteUserURLs
+	clickChan    chan model.Click
 	buf          []model.DeleteUserURLs
 	timer        *time.Timer
 	isTimeout    bool
@@ -48,7 +83,9 @@ func NewService(st Storage) *Service {
 	s := Service{
 		st:           st,
 		Gen:          model.Generator{},
+		Validator:    NewURLValidator(config.AllowedSchemes, config.URLMaxLen, false),
 		deletionChan: make(chan model.DeleteUserURLs),
+		clickChan:    make(chan model.Click, config.ClickChanLen),
 		buf:          make([]model.DeleteUserURLs, 0, config.BufLen),
 		isTimeout:    true,
 		timer:        time.NewTimer(0),
@@ -59,33 +96,71 @@ func NewService(st Storage) *Service {
 
 func (s *Service) RunWorker() {
 	go s.worker()
+	go s.clickWorker()
 }
 
 func (s *Service) SetDB(db Database) {
 	s.db = db
 }
 
+// prepareOrigin normalizes the original URL, resolves the links to the service itself and checks the domain policy
+func (s *Service) prepareOrigin(ctx context.Context, originURL string) (string, error) {
+	originURL, err := s.Validator.Normalize(originURL)
+	if err != nil {
+		return "", err
+	}
+	if originURL, err = s.resolveSelfLink(ctx, originURL); err != nil {
+		return "", err
+	}
+	if err = s.checkDomain(originURL); err != nil {
+		return "", err
+	}
+	return originURL, nil
+}
+
 func (s *Service) GetURLModel(ctx context.Context, userID string, originURL string) (*model.URL, error) {
+	return s.GetURLModelWithOptions(ctx, userID, originURL, model.ShortenOptions{})
+}
+
+// GetURLModelWithOptions shortens the URL taking into account the optional parameters, e.g. the custom alias
+func (s *Service) GetURLModelWithOptions(ctx context.Context, userID string, originURL string, opts model.ShortenOptions) (*model.URL, error) {
 
 	var urlModel *model.URL
 
-	for {
-		shortURL := s.Gen.MakeShortURL()
-		urlModel, _ = s.st.GetByID(ctx, shortURL)
+	originURL, err := s.prepareOrigin(ctx, originURL)
+	if err != nil {
+		return nil, err
+	}
 
-		if urlModel == nil {
-			urlModel = model.NewURL(originURL, shortURL)
-			err := s.st.Add(ctx, userID, urlModel)
-			if err != nil {
-				return nil, err
-			}
-		} else if urlModel.Origin != originURL {
-			continue
+	expiresAt, err := resolveExpiration(opts.ExpiresAt, opts.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Alias != "" {
+		if err := validateAlias(opts.Alias); err != nil {
+			return nil, err
+		}
+		if err := s.checkAliasFree(ctx, opts.Alias); err != nil {
+			return nil, err
 		}
-		break
+		urlModel = model.NewURL(originURL, opts.Alias)
+		urlModel.ExpiresAt = expiresAt
+		if err := s.add(ctx, userID, urlModel); err != nil {
+			return nil, err
+		}
+		return urlModel, nil
 	}
 
-	return urlModel, nil
+	if gen, ok := s.Gen.(KeyedGenerator); ok {
+		urlModel = model.NewURL(originURL, "")
+		urlModel.ExpiresAt = expiresAt
+		return s.addKeyed(ctx, gen, userID, urlModel)
+	}
+
+	urlModel = model.NewURL(originURL, "")
+	urlModel.ExpiresAt = expiresAt
+	return s.addUnique(ctx, userID, urlModel)
 }
 
 func (s *Service) GetURLModelByID(ctx context.Context, shortURL string) (*model.URL, error) {
@@ -94,6 +169,12 @@ func (s *Service) GetURLModelByID(ctx context.Context, shortURL string) (*model.
 	if err != nil {
 		return nil, err
 	}
+	if urlModel.IsExpired(time.Now()) {
+		return nil, model.ExpiredError{ShortenURL: shortURL}
+	}
+	if err = s.checkDomain(urlModel.Origin); err != nil {
+		return nil, err
+	}
 	return urlModel, nil
 }
 
@@ -106,6 +187,20 @@ func (s *Service) GetUserURLs(ctx context.Context, userID string) ([]*model.URL,
 	return userURLs, nil
 }
 
+// UpdateURL changes the original URL of the user's shortened link
+func (s *Service) UpdateURL(ctx context.Context, userID string, shortURL string, originURL string) (*model.URL, error) {
+	originURL, err := s.prepareOrigin(ctx, originURL)
+	if err != nil {
+		return nil, err
+	}
+
+	urlModel := model.NewURL(originURL, shortURL)
+	if err := s.st.Update(ctx, userID, urlModel); err != nil {
+		return nil, err
+	}
+	return urlModel, nil
+}
+
 func (s *Service) Ping(ctx context.Context) error {
 	if s.db == nil {
 		log.Printf("Ping error: database not initialized")
@@ -119,21 +214,68 @@ func (s *Service) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (s *Service) ShortenBatch(ctx context.Context, userID string, input []model.InputCorrelationURL) ([]model.OutputCorrelationURL, error) {
+// ShortenBatch writes all URLs or none of them, e.g. if the batch exceeds the user's quota
+func (s *Service) ShortenBatch(ctx context.Context, userID string, input []model.InputCorrelationURL) (_ []model.OutputCorrelationURL, err error) {
 
 	output := make([]model.OutputCorrelationURL, 0, len(input))
 	urls := make(map[string]*model.URL)
+	pooled := make([]string, 0)
+	defer func() {
+		if err != nil {
+			s.releaseKeys(ctx, pooled...)
+		}
+	}()
+
 	for _, correlationURL := range input {
 		var urlModel *model.URL
 
-		for {
-			shortURL := s.Gen.MakeShortURL()
+		originURL, err := s.prepareOrigin(ctx, correlationURL.Origin)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl := time.Duration(correlationURL.TTLSeconds) * time.Second
+		expiresAt, err := resolveExpiration(correlationURL.ExpiresAt, ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		if correlationURL.Alias != "" {
+			if err := validateAlias(correlationURL.Alias); err != nil {
+				return nil, err
+			}
+			if _, ok := urls[correlationURL.Alias]; ok {
+				return nil, model.AliasConflictError{Alias: correlationURL.Alias}
+			}
+			if err := s.checkAliasFree(ctx, correlationURL.Alias); err != nil {
+				return nil, err
+			}
+			urlModel = model.NewURL(originURL, correlationURL.Alias)
+			urlModel.ExpiresAt = expiresAt
+			urls[correlationURL.Alias] = urlModel
+		}
+
+		if gen, ok := s.Gen.(KeyedGenerator); ok && urlModel == nil {
+			urlModel, err = s.makeKeyedBatchURL(ctx, gen, userID, originURL, expiresAt, urls)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for urlModel == nil {
+			shortURL, err := s.makeShortURL(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if s.Pool != nil {
+				pooled = append(pooled, shortURL)
+			}
 			if _, ok := urls[shortURL]; ok {
 				continue
 			}
-			urlModel = model.NewURL(correlationURL.Origin, shortURL)
+			urlModel = model.NewURL(originURL, shortURL)
+			urlModel.ExpiresAt = expiresAt
 			urls[shortURL] = urlModel
-			break
 		}
 
 		out := model.OutputCorrelationURL{
@@ -144,8 +286,7 @@ func (s *Service) ShortenBatch(ctx context.Context, userID string, input []model
 		output = append(output, out)
 	}
 
-	err := s.db.WriteBatch(ctx, userID, urls)
-	if err != nil {
+	if err = s.writeBatch(ctx, userID, urls); err != nil {
 		return nil, err
 	}
 
@@ -224,8 +365,13 @@ func (s *Service) GetStats(ctx context.Context) (model.Stats, error) {
 		return model.Stats{}, err
 	}
 
-	return model.Stats{
+	stats := model.Stats{
 		NumberOfURLs:  numberOfURLs,
 		NumberOfUsers: numberOfUsers,
-	}, nil
+	}
+	if cache, ok := s.st.(ICacheStats); ok {
+		cacheStats := cache.CacheStats()
+		stats.Cache = &cacheStats
+	}
+	return stats, nil
 }
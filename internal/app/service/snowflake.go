@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+// NodeLeaser is implemented by the storages shared by the instances, they give out the unique node IDs
+// of the snowflake generator
+type NodeLeaser interface {
+	LeaseNode(ctx context.Context, owner string, maxNode int64, ttl time.Duration) (int64, error)
+	RenewNode(ctx context.Context, node int64, owner string, ttl time.Duration) error
+	ReleaseNode(ctx context.Context, node int64, owner string) error
+}
+
+// NodeHolder is the generator that must stop while the node ID is not held
+type NodeHolder interface {
+	Suspend()
+	Resume(node int64)
+}
+
+// NodeLease keeps the node ID leased while the instance runs. Node is the node leased at the start,
+// the renewal may lease another one
+type NodeLease struct {
+	Node   int64
+	mu     sync.Mutex
+	leaser NodeLeaser
+	owner  string
+	ttl    time.Duration
+	stop   chan struct{}
+}
+
+// LeaseNode leases the node ID in the storage. The storage that is not shared by the instances
+// can not lease, the only instance gets the node 0
+func LeaseNode(ctx context.Context, st Storage, ttl time.Duration) (*NodeLease, error) {
+	leaser, ok := st.(NodeLeaser)
+	if !ok {
+		return &NodeLease{}, nil
+	}
+
+	owner, err := randomHex(config.UserIDLen)
+	if err != nil {
+		return nil, err
+	}
+	node, err := leaser.LeaseNode(ctx, owner, model.MaxSnowflakeNode, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeLease{Node: node, leaser: leaser, owner: owner, ttl: ttl, stop: make(chan struct{})}, nil
+}
+
+// Run renews the lease in the background three times per its TTL. If the renewal fails, the holder
+// is suspended at once, long before the lease expires, and resumed when the node or a new one is held again
+func (l *NodeLease) Run(holder NodeHolder) {
+	if l.leaser == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				l.renew(holder)
+			}
+		}
+	}()
+}
+
+// renew extends the lease or leases a node again. The calls are bounded by the renewal interval,
+// so a hanging storage does not keep the holder running past the lease
+func (l *NodeLease) renew(holder NodeHolder) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+	defer cancel()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.leaser.RenewNode(ctx, l.Node, l.owner, l.ttl)
+	if err == nil {
+		holder.Resume(l.Node)
+		return
+	}
+	log.Printf("node lease renew error: %s", err)
+	holder.Suspend()
+
+	node, err := l.leaser.LeaseNode(ctx, l.owner, model.MaxSnowflakeNode, l.ttl)
+	if err != nil {
+		log.Printf("node lease error: %s", err)
+		return
+	}
+	l.Node = node
+	holder.Resume(node)
+	log.Printf("leased node ID %d", node)
+}
+
+// Release stops the renewal and frees the node
+func (l *NodeLease) Release(ctx context.Context) error {
+	if l.leaser == nil {
+		return nil
+	}
+	close(l.stop)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.leaser.ReleaseNode(ctx, l.Node, l.owner)
+}
@@ -0,0 +1,261 @@
+// Package cache keeps the recent lookups of the shortened URLs in RAM in front of the storage
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/service"
+)
+
+// entry is the result of the lookup: the link or the error of the missing, deleted or expired link
+type entry struct {
+	key       string
+	url       *model.URL
+	err       error
+	expiresAt time.Time
+}
+
+// load is the lookup of the key in flight. The invalidation of the key bumps the generation,
+// so the result loaded before it is not cached
+type load struct {
+	refs       int
+	generation uint64
+}
+
+// lru evicts the least recently used entry when the size is exceeded and drops the entries older than the TTL
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List
+	loads map[string]*load
+	now   func() time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+		loads: make(map[string]*load),
+		now:   time.Now,
+	}
+}
+
+// begin registers the lookup of the key in the storage and returns the generation to pass to finish
+func (c *lru) begin(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.loads[key]
+	if !ok {
+		l = &load{}
+		c.loads[key] = l
+	}
+	l.refs++
+	return l.generation
+}
+
+// finish caches the loaded result unless the key has been invalidated since begin
+func (c *lru) finish(key string, generation uint64, cache bool, url *model.URL, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l := c.loads[key]
+	if l.refs--; l.refs == 0 {
+		delete(c.loads, key)
+	}
+	if cache && l.generation == generation {
+		c.put(key, url, err)
+	}
+}
+
+func (c *lru) get(key string) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if !c.now().Before(e.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return e, true
+}
+
+// put caches the result, the caller must hold the lock
+func (c *lru) put(key string, url *model.URL, err error) {
+	e := &entry{key: key, url: url, err: err, expiresAt: c.now().Add(c.ttl)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = e
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *lru) remove(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.removeElement(elem)
+		}
+		if l, ok := c.loads[key]; ok {
+			l.generation++
+		}
+	}
+}
+
+// removeElement removes the entry, the caller must hold the lock
+func (c *lru) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*entry).key)
+}
+
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// Storage is the read-through cache of GetByID in front of any storage. The deleted and expired links
+// are cached as well, the missing ones only if negative is set, other errors are not. Add and Update invalidate
+// the link, also the lookup that is in flight during the invalidation is not cached. The cache of one instance
+// does not see the changes made by the others, they are visible after the TTL
+type Storage struct {
+	service.Storage
+	cache    *lru
+	negative bool
+	hits     uint64
+	misses   uint64
+}
+
+func New(st service.Storage, size int, ttl time.Duration, negative bool) *Storage {
+	return &Storage{Storage: st, cache: newLRU(size, ttl), negative: negative}
+}
+
+func (s *Storage) GetByID(ctx context.Context, id string) (*model.URL, error) {
+	if e, ok := s.cache.get(id); ok {
+		atomic.AddUint64(&s.hits, 1)
+		return clone(e.url), e.err
+	}
+	atomic.AddUint64(&s.misses, 1)
+
+	generation := s.cache.begin(id)
+	url, err := s.Storage.GetByID(ctx, id)
+	s.cache.finish(id, generation, s.isCacheable(err), clone(url), err)
+	return url, err
+}
+
+func (s *Storage) Add(ctx context.Context, userID string, url *model.URL) error {
+	defer s.cache.remove(url.Short)
+	return s.Storage.Add(ctx, userID, url)
+}
+
+func (s *Storage) Update(ctx context.Context, userID string, url *model.URL) error {
+	defer s.cache.remove(url.Short)
+	return s.Storage.Update(ctx, userID, url)
+}
+
+// CacheStats returns the hit and miss counters and the number of the cached links
+func (s *Storage) CacheStats() model.CacheStats {
+	return model.CacheStats{
+		Hits:   atomic.LoadUint64(&s.hits),
+		Misses: atomic.LoadUint64(&s.misses),
+		Size:   s.cache.len(),
+	}
+}
+
+// Database is Storage in front of the database, the batch writes and the deletions invalidate the links too.
+// MarkExpired does not: the cached links keep their expiration time, which the service checks itself
+type Database struct {
+	service.Database
+	cache *Storage
+}
+
+func NewDatabase(db service.Database, size int, ttl time.Duration, negative bool) *Database {
+	return &Database{Database: db, cache: New(db, size, ttl, negative)}
+}
+
+func (d *Database) GetByID(ctx context.Context, id string) (*model.URL, error) {
+	return d.cache.GetByID(ctx, id)
+}
+
+func (d *Database) Add(ctx context.Context, userID string, url *model.URL) error {
+	return d.cache.Add(ctx, userID, url)
+}
+
+func (d *Database) Update(ctx context.Context, userID string, url *model.URL) error {
+	return d.cache.Update(ctx, userID, url)
+}
+
+func (d *Database) WriteBatch(ctx context.Context, userID string, urls map[string]*model.URL) error {
+	defer func() {
+		for short := range urls {
+			d.cache.cache.remove(short)
+		}
+	}()
+	return d.Database.WriteBatch(ctx, userID, urls)
+}
+
+func (d *Database) AddWithQuota(ctx context.Context, userID string, url *model.URL, limit int) error {
+	defer d.cache.cache.remove(url.Short)
+	return d.Database.AddWithQuota(ctx, userID, url, limit)
+}
+
+func (d *Database) WriteBatchWithQuota(ctx context.Context, userID string, urls map[string]*model.URL, limit int) error {
+	defer func() {
+		for short := range urls {
+			d.cache.cache.remove(short)
+		}
+	}()
+	return d.Database.WriteBatchWithQuota(ctx, userID, urls, limit)
+}
+
+func (d *Database) DeleteBatch(ctx context.Context, toDelete []model.DeleteUserURLs) error {
+	defer func() {
+		for _, url := range toDelete {
+			d.cache.cache.remove(url.Short)
+		}
+	}()
+	return d.Database.DeleteBatch(ctx, toDelete)
+}
+
+func (d *Database) CacheStats() model.CacheStats {
+	return d.cache.CacheStats()
+}
+
+// isCacheable checks whether the result of the lookup is stable, the other errors may be transient
+func (s *Storage) isCacheable(err error) bool {
+	return err == nil ||
+		(s.negative && errors.As(err, &model.NotFoundError{})) ||
+		errors.As(err, &model.GoneError{}) ||
+		errors.As(err, &model.ExpiredError{})
+}
+
+// clone copies the link, so the callers can not change the cached one
+func clone(url *model.URL) *model.URL {
+	if url == nil {
+		return nil
+	}
+	c := *url
+	return &c
+}
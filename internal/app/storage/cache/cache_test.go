@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts the lookups that reach the storage
+type countingStorage struct {
+	*storage.URLStorage
+	lookups int
+}
+
+func (c *countingStorage) GetByID(ctx context.Context, id string) (*model.URL, error) {
+	c.lookups++
+	return c.URLStorage.GetByID(ctx, id)
+}
+
+func TestDatabaseGetByID(t *testing.T) {
+	ctx := context.Background()
+	st := &countingStorage{URLStorage: storage.NewUrls()}
+	db := NewDatabase(st, 10, time.Minute, true)
+
+	require.NoError(t, db.Add(ctx, "owner", model.NewURL("https://example.com", "qwertyT")))
+
+	for i := 0; i < 3; i++ {
+		url, err := db.GetByID(ctx, "qwertyT")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", url.Origin)
+		url.Origin = "changed"
+	}
+	for i := 0; i < 2; i++ {
+		_, err := db.GetByID(ctx, "missing")
+		assert.ErrorAs(t, err, &model.NotFoundError{})
+	}
+
+	assert.Equal(t, 2, st.lookups, "the links and the missing ones are cached")
+	assert.Equal(t, model.CacheStats{Hits: 3, Misses: 2, Size: 2}, db.CacheStats())
+}
+
+func TestDatabaseInvalidation(t *testing.T) {
+	ctx := context.Background()
+	st := &countingStorage{URLStorage: storage.NewUrls()}
+	db := NewDatabase(st, 10, time.Minute, true)
+
+	_, err := db.GetByID(ctx, "qwertyT")
+	require.ErrorAs(t, err, &model.NotFoundError{})
+	require.NoError(t, db.WriteBatch(ctx, "owner", map[string]*model.URL{"qwertyT": model.NewURL("https://example.com", "qwertyT")}))
+
+	url, err := db.GetByID(ctx, "qwertyT")
+	require.NoError(t, err, "the batch invalidates the missing link")
+	assert.Equal(t, "https://example.com", url.Origin)
+
+	require.NoError(t, db.Update(ctx, "owner", model.NewURL("https://example.org", "qwertyT")))
+	url, err = db.GetByID(ctx, "qwertyT")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.org", url.Origin, "the update invalidates the link")
+
+	require.NoError(t, db.DeleteBatch(ctx, []model.DeleteUserURLs{{UserID: "owner", Short: "qwertyT"}}))
+	for i := 0; i < 2; i++ {
+		_, err = db.GetByID(ctx, "qwertyT")
+		assert.ErrorAs(t, err, &model.GoneError{}, "the deletion invalidates the link")
+	}
+	assert.Equal(t, 4, st.lookups, "the deleted link is cached")
+}
+
+// slowStorage holds the lookup after reading the link until it is released
+type slowStorage struct {
+	*storage.URLStorage
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStorage) GetByID(ctx context.Context, id string) (*model.URL, error) {
+	url, err := s.URLStorage.GetByID(ctx, id)
+	s.read <- struct{}{}
+	<-s.release
+	return url, err
+}
+
+func TestDatabaseUpdateDuringMiss(t *testing.T) {
+	ctx := context.Background()
+	st := &slowStorage{URLStorage: storage.NewUrls(), read: make(chan struct{}), release: make(chan struct{})}
+	db := NewDatabase(st, 10, time.Minute, true)
+	require.NoError(t, st.URLStorage.Add(ctx, "owner", model.NewURL("https://example.com", "qwertyT")))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		url, err := db.GetByID(ctx, "qwertyT")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com", url.Origin, "the lookup returns what it has read")
+	}()
+
+	<-st.read
+	require.NoError(t, db.Update(ctx, "owner", model.NewURL("https://example.org", "qwertyT")))
+	close(st.release)
+	<-done
+
+	assert.Equal(t, 0, db.CacheStats().Size, "the link read before the update is not cached")
+
+	go func() { <-st.read }()
+	url, err := db.GetByID(ctx, "qwertyT")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.org", url.Origin)
+	assert.Equal(t, 1, db.CacheStats().Size)
+}
+
+func TestLRU(t *testing.T) {
+	now := time.Now()
+	c := newLRU(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.put("a", model.NewURL("https://example.com/a", "a"), nil)
+	c.put("b", model.NewURL("https://example.com/b", "b"), nil)
+	_, ok := c.get("a")
+	require.True(t, ok)
+	c.put("c", model.NewURL("https://example.com/c", "c"), nil)
+
+	_, ok = c.get("b")
+	assert.False(t, ok, "the least recently used entry is evicted")
+	_, ok = c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.len())
+
+	now = now.Add(time.Minute)
+	_, ok = c.get("a")
+	assert.False(t, ok, "the entry is dropped after the TTL")
+	assert.Equal(t, 1, c.len())
+}
+
+func TestDatabaseWithoutNegative(t *testing.T) {
+	ctx := context.Background()
+	st := &countingStorage{URLStorage: storage.NewUrls()}
+	db := NewDatabase(st, 10, time.Minute, false)
+
+	_, err := db.GetByID(ctx, "qwertyT")
+	assert.ErrorAs(t, err, &model.NotFoundError{})
+
+	require.NoError(t, st.Add(ctx, "owner", model.NewURL("https://example.com", "qwertyT")))
+
+	url, err := db.GetByID(ctx, "qwertyT")
+	require.NoError(t, err, "the link added by another instance is found at once")
+	assert.Equal(t, "https://example.com", url.Origin)
+	assert.Equal(t, 2, st.lookups)
+}
@@ -2,23 +2,61 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/kotche/url-shortening-service/internal/app/model"
 )
 
+// logVersion is the version of the operation log record format. Records without a version are legacy DataFile
+const logVersion = 1
+
+const (
+	opAdd    = "add"
+	opUpdate = "update"
+	opDelete = "delete"
+	opClick  = "click"
+
+	opAddAPIKey    = "add_api_key"
+	opRevokeAPIKey = "revoke_api_key"
+	opAddUser      = "add_user"
+	opMergeURLs    = "merge_urls"
+	opSequence     = "sequence"
+)
+
+// FileStorage keeps the URL index in RAM and writes every change to the operation log.
+// The file mutex orders the records in the log the same way as the changes in RAM.
+// Expiration marks are not written: they are restored by the sweeper from the expiration time
 type FileStorage struct {
-	file      *os.File
-	encoder   *json.Encoder
-	urls      map[string]*model.URL
-	urlsUsers map[string][]*model.URL
+	*URLStorage
+	mu       sync.Mutex
+	fileName string
+	file     *os.File
+	encoder  *json.Encoder
+}
+
+// Record is an operation of the file storage log
+type Record struct {
+	Version int           `json:"v"`
+	Op      string        `json:"op"`
+	Owner   string        `json:"owner,omitempty"`
+	URL     *model.URL    `json:"url,omitempty"`
+	Click   *model.Click  `json:"click,omitempty"`
+	APIKey  *model.APIKey `json:"api_key,omitempty"`
+	User    *model.User   `json:"user,omitempty"`
+	From    string        `json:"from,omitempty"`
+	Seq     uint64        `json:"seq,omitempty"`
 }
 
-// DataFile store the URL in the file system
+// DataFile store the URL in the file system. Legacy record format, read only for compatibility
 type DataFile struct {
 	Owner string `json:"owner"`
 	*model.URL
@@ -30,66 +68,423 @@ func NewFileStorage(fileName string) (*FileStorage, error) {
 		return nil, err
 	}
 
-	urls := make(map[string]*model.URL)
-	urlsUsers := make(map[string][]*model.URL)
+	urls := NewUrls()
+	if err = replay(file, urls); err != nil {
+		file.Close()
+		return nil, err
+	}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		dataFile := &DataFile{}
-		err = json.Unmarshal(scanner.Bytes(), dataFile)
-		if err != nil {
-			return nil, err
+	return &FileStorage{
+		URLStorage: urls,
+		fileName:   fileName,
+		file:       file,
+		encoder:    json.NewEncoder(file),
+	}, nil
+}
+
+// replay restores the state from the log. A broken last record is left by a crash during writing,
+// so it is cut off. A broken record in the middle of the log is an error
+func replay(file *os.File, urls *URLStorage) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return err
 		}
-		urls[dataFile.Short] = dataFile.URL
-		urlsUsers[dataFile.Owner] = append(urlsUsers[dataFile.Owner], dataFile.URL)
+
+		record, errDecode := decodeRecord(line)
+		if errDecode != nil {
+			if _, errPeek := reader.Peek(1); !errors.Is(errPeek, io.EOF) {
+				return fmt.Errorf("file storage: broken record at offset %d: %w", offset, errDecode)
+			}
+			log.Printf("file storage: truncating broken last record at offset %d: %s", offset, errDecode)
+			return file.Truncate(offset)
+		}
+
+		if err = urls.apply(record); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+
+		if line[len(line)-1] != '\n' {
+			_, err = file.Write([]byte{'\n'})
+			return err
+		}
+	}
+}
+
+// decodeRecord parses the log line in the current or the legacy format
+func decodeRecord(line []byte) (*Record, error) {
+	line = bytes.TrimSpace(line)
+
+	record := &Record{}
+	if err := json.Unmarshal(line, record); err != nil {
+		return nil, err
+	}
+	if record.Version != 0 {
+		return record, nil
 	}
 
-	if err = scanner.Err(); err != nil {
+	dataFile := &DataFile{}
+	if err := json.Unmarshal(line, dataFile); err != nil {
 		return nil, err
 	}
+	return &Record{Op: opAdd, Owner: dataFile.Owner, URL: dataFile.URL}, nil
+}
 
-	return &FileStorage{
-		file:      file,
-		encoder:   json.NewEncoder(file),
-		urls:      urls,
-		urlsUsers: urlsUsers,
-	}, nil
+// apply changes the state by the log record, the caller must hold the lock
+func (m *URLStorage) apply(record *Record) error {
+	switch {
+	case record.Op == opAdd && record.URL != nil:
+		m.add(record.Owner, record.URL)
+	case record.Op == opUpdate && record.URL != nil:
+		return m.update(record.Owner, record.URL)
+	case record.Op == opDelete && record.URL != nil:
+		m.delete([]model.DeleteUserURLs{{UserID: record.Owner, Short: record.URL.Short}})
+	case record.Op == opClick && record.Click != nil:
+		m.addClicks([]model.Click{*record.Click})
+	case record.Op == opAddAPIKey && record.APIKey != nil:
+		return m.addAPIKey(record.APIKey)
+	case record.Op == opRevokeAPIKey && record.APIKey != nil && record.APIKey.RevokedAt != nil:
+		return m.revokeAPIKey(record.Owner, record.APIKey.ID, *record.APIKey.RevokedAt)
+	case record.Op == opAddUser && record.User != nil:
+		return m.createUser(record.User)
+	case record.Op == opMergeURLs && record.From != "":
+		m.mergeUserURLs(record.From, record.Owner)
+	case record.Op == opSequence:
+		if record.Seq > m.sequence {
+			m.sequence = record.Seq
+		}
+	default:
+		return fmt.Errorf("file storage: unknown operation %q", record.Op)
+	}
+	return nil
 }
 
-func (f *FileStorage) Add(_ context.Context, userID string, url *model.URL) error {
-	mu := &sync.Mutex{}
-	mu.Lock()
-	defer mu.Unlock()
+func (f *FileStorage) write(op string, owner string, url *model.URL, click *model.Click) error {
+	return f.encoder.Encode(&Record{Version: logVersion, Op: op, Owner: owner, URL: url, Click: click})
+}
 
-	dataFile := &DataFile{}
-	dataFile.URL = url
-	dataFile.Owner = userID
+func (f *FileStorage) Add(ctx context.Context, userID string, url *model.URL) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.add(ctx, userID, url)
+}
+
+// AddWithQuota adds the link if the user has less than limit live links. The file mutex orders all writes,
+// so the check and the write are atomic
+func (f *FileStorage) AddWithQuota(ctx context.Context, userID string, url *model.URL, limit int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.URLStorage.canAdd(userID, url); err != nil {
+		return err
+	}
+	used, err := f.URLStorage.CountUserURLs(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = checkQuota(used, 1, limit); err != nil {
+		return err
+	}
+	return f.add(ctx, userID, url)
+}
+
+// add writes the link to the log and to RAM, the caller must hold the file lock
+func (f *FileStorage) add(ctx context.Context, userID string, url *model.URL) error {
+	if err := f.URLStorage.canAdd(userID, url); err != nil {
+		return err
+	}
+
+	err := f.write(opAdd, userID, url, nil)
+	if err != nil {
+		return err
+	}
+
+	return f.URLStorage.Add(ctx, userID, url)
+}
+
+// NextSequence writes the last given value to the log, so the values are not given out again after the restart
+func (f *FileStorage) NextSequence(ctx context.Context, n int) ([]uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values, err := f.URLStorage.NextSequence(ctx, n)
+	if err != nil || len(values) == 0 {
+		return values, err
+	}
+
+	err = f.encoder.Encode(&Record{Version: logVersion, Op: opSequence, Seq: values[len(values)-1]})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (f *FileStorage) Update(ctx context.Context, userID string, url *model.URL) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.URLStorage.Update(ctx, userID, url)
+	if err != nil {
+		return err
+	}
+
+	return f.write(opUpdate, userID, model.NewURL(url.Origin, url.Short), nil)
+}
+
+func (f *FileStorage) WriteBatch(ctx context.Context, userID string, urls map[string]*model.URL) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writeBatch(ctx, userID, urls)
+}
+
+// WriteBatchWithQuota writes the links if all of them fit into the user's limit of live links
+func (f *FileStorage) WriteBatchWithQuota(ctx context.Context, userID string, urls map[string]*model.URL, limit int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	used, err := f.URLStorage.CountUserURLs(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = checkQuota(used, len(urls), limit); err != nil {
+		return err
+	}
+	return f.writeBatch(ctx, userID, urls)
+}
+
+// writeBatch writes the links to RAM and to the log, the caller must hold the file lock
+func (f *FileStorage) writeBatch(ctx context.Context, userID string, urls map[string]*model.URL) error {
+	err := f.URLStorage.WriteBatch(ctx, userID, urls)
+	if err != nil {
+		return err
+	}
+
+	for _, url := range urls {
+		if err = f.write(opAdd, userID, url, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStorage) DeleteBatch(ctx context.Context, toDelete []model.DeleteUserURLs) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.URLStorage.DeleteBatch(ctx, toDelete)
+	if err != nil {
+		return err
+	}
+
+	for _, url := range toDelete {
+		if err = f.write(opDelete, url.UserID, &model.URL{Short: url.Short}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStorage) WriteClicks(ctx context.Context, clicks []model.Click) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.URLStorage.WriteClicks(ctx, clicks)
+	if err != nil {
+		return err
+	}
+
+	for i := range clicks {
+		if err = f.write(opClick, "", nil, &clicks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStorage) AddAPIKey(ctx context.Context, key *model.APIKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.URLStorage.AddAPIKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return f.encoder.Encode(&Record{Version: logVersion, Op: opAddAPIKey, APIKey: key})
+}
+
+func (f *FileStorage) RevokeAPIKey(ctx context.Context, userID string, id string, revokedAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.URLStorage.RevokeAPIKey(ctx, userID, id, revokedAt)
+	if err != nil {
+		return err
+	}
+
+	return f.encoder.Encode(&Record{Version: logVersion, Op: opRevokeAPIKey, Owner: userID,
+		APIKey: &model.APIKey{ID: id, RevokedAt: &revokedAt}})
+}
+
+func (f *FileStorage) CreateUser(ctx context.Context, user *model.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.URLStorage.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	return f.encoder.Encode(&Record{Version: logVersion, Op: opAddUser, User: user})
+}
 
-	f.urls[url.Short] = url
-	f.urlsUsers[userID] = append(f.urlsUsers[userID], url)
+func (f *FileStorage) MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	err := f.encoder.Encode(dataFile)
+	n, err := f.URLStorage.MergeUserURLs(ctx, fromUserID, toUserID)
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	return n, f.encoder.Encode(&Record{Version: logVersion, Op: opMergeURLs, Owner: toUserID, From: fromUserID})
+}
+
+// MergeUserURLsWithQuota moves the links if the live ones fit into the target user's limit.
+// The log records the whole merge, so the replay does not depend on the quota
+func (f *FileStorage) MergeUserURLsWithQuota(ctx context.Context, fromUserID string, toUserID string, limit int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.URLStorage.MergeUserURLsWithQuota(ctx, fromUserID, toUserID, limit)
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	return n, f.encoder.Encode(&Record{Version: logVersion, Op: opMergeURLs, Owner: toUserID, From: fromUserID})
+}
+
+// Compact rewrites the log as a snapshot of the current state: updates are folded into the links,
+// and legacy records are converted to the current format. The snapshot replaces the log by an atomic rename
+func (f *FileStorage) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmpName := f.fileName + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpName)
+
+	writer := bufio.NewWriter(tmp)
+	if err = f.snapshot(json.NewEncoder(writer)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpName, f.fileName); err != nil {
+		return err
+	}
 
+	file, err := os.OpenFile(f.fileName, os.O_RDWR|os.O_APPEND, 0777)
+	if err != nil {
+		return err
+	}
+	if err = f.file.Close(); err != nil {
+		log.Printf("file storage: closing old log: %s", err)
+	}
+	f.file = file
+	f.encoder = json.NewEncoder(file)
 	return nil
 }
 
-func (f *FileStorage) GetByID(_ context.Context, id string) (*model.URL, error) {
-	original, ok := f.urls[id]
-	if !ok {
-		return nil, fmt.Errorf("key not found")
+// snapshot writes the records that restore the current state
+func (f *FileStorage) snapshot(encoder *json.Encoder) error {
+	f.URLStorage.mu.RLock()
+	defer f.URLStorage.mu.RUnlock()
+
+	for owner, urls := range f.urlsUsers {
+		for _, url := range urls {
+			err := encoder.Encode(&Record{Version: logVersion, Op: opAdd, Owner: owner, URL: url})
+			if err != nil {
+				return err
+			}
+			if !f.deleted[url.Short] {
+				continue
+			}
+			err = encoder.Encode(&Record{Version: logVersion, Op: opDelete, Owner: owner, URL: &model.URL{Short: url.Short}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, clicks := range f.clicks {
+		for i := range clicks {
+			err := encoder.Encode(&Record{Version: logVersion, Op: opClick, Click: &clicks[i]})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range f.apiKeys {
+		err := encoder.Encode(&Record{Version: logVersion, Op: opAddAPIKey, APIKey: key})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, user := range f.users {
+		err := encoder.Encode(&Record{Version: logVersion, Op: opAddUser, User: user})
+		if err != nil {
+			return err
+		}
 	}
 
-	return original, nil
+	if f.sequence > 0 {
+		return encoder.Encode(&Record{Version: logVersion, Op: opSequence, Seq: f.sequence})
+	}
+	return nil
 }
 
-func (f *FileStorage) GetUserURLs(_ context.Context, userID string) ([]*model.URL, error) {
-	usersURLs := f.urlsUsers[userID]
-	return usersURLs, nil
+// RunCompaction compacts the log periodically
+func (f *FileStorage) RunCompaction(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := f.Compact(); err != nil {
+				log.Printf("file storage compaction error: %s", err)
+			}
+		}
+	}()
 }
 
 func (f *FileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	return f.file.Close()
 }
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageLegacyFormat(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+	legacy := `{"owner":"owner","short_url":"qwertyT","original_url":"https://example.com"}
+{"owner":"another","short_url":"asdfghJ","original_url":"https://example.org"}
+`
+	require.NoError(t, os.WriteFile(fileName, []byte(legacy), 0644))
+
+	st, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+	defer st.Close()
+
+	url, err := st.GetByID(context.Background(), "qwertyT")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", url.Origin)
+
+	urls, err := st.GetUserURLs(context.Background(), "another")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "asdfghJ", urls[0].Short)
+}
+
+func TestFileStorageTruncatedLastRecord(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+	data := `{"v":1,"op":"add","owner":"owner","url":{"short_url":"qwertyT","original_url":"https://example.com"}}
+{"v":1,"op":"add","owner":"owner","url":{"short_url":"asdfghJ","orig`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+
+	st, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = st.GetByID(ctx, "asdfghJ")
+	assert.Error(t, err)
+
+	require.NoError(t, st.Add(ctx, "owner", model.NewURL("https://example.net", "zxcvbnM")))
+	require.NoError(t, st.Close())
+
+	restored, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	urls, err := restored.GetUserURLs(ctx, "owner")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestFileStorageBrokenRecordInTheMiddle(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+	data := `{"v":1,"op":"add","owner":"owner","url":{"short_url":"qwertyT","orig
+{"v":1,"op":"add","owner":"owner","url":{"short_url":"asdfghJ","original_url":"https://example.com"}}
+`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0644))
+
+	_, err := NewFileStorage(fileName)
+	assert.Error(t, err)
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+
+	st, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+
+	require.NoError(t, st.Add(ctx, "owner", model.NewURL("https://example.com/1", "qwertyT")))
+	require.NoError(t, st.Add(ctx, "owner", model.NewURL("https://example.com/2", "asdfghJ")))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, st.Update(ctx, "owner", model.NewURL("https://example.org/"+strings.Repeat("a", i), "qwertyT")))
+	}
+	require.NoError(t, st.DeleteBatch(ctx, []model.DeleteUserURLs{{UserID: "owner", Short: "asdfghJ"}}))
+	require.NoError(t, st.AddAPIKey(ctx, &model.APIKey{ID: "key1", UserID: "owner", Hash: "hash1", Scopes: []string{model.ScopeRead}}))
+	require.NoError(t, st.RevokeAPIKey(ctx, "owner", "key1", time.Now()))
+
+	before, err := os.Stat(fileName)
+	require.NoError(t, err)
+
+	require.NoError(t, st.Compact())
+
+	after, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	require.NoError(t, st.Add(ctx, "owner", model.NewURL("https://example.com/3", "zxcvbnM")))
+	require.NoError(t, st.Close())
+
+	restored, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	url, err := restored.GetByID(ctx, "qwertyT")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.org/aaaaaaaaa", url.Origin)
+
+	_, err = restored.GetByID(ctx, "asdfghJ")
+	assert.ErrorAs(t, err, &model.GoneError{})
+
+	_, err = restored.GetByID(ctx, "zxcvbnM")
+	assert.NoError(t, err)
+
+	key, err := restored.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	assert.True(t, key.IsRevoked())
+}
@@ -7,6 +7,7 @@ package mock_service
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	model "github.com/kotche/url-shortening-service/internal/app/model"
@@ -93,6 +94,20 @@ func (mr *MockStorageMockRecorder) GetUserURLs(ctx, userID interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLs", reflect.TypeOf((*MockStorage)(nil).GetUserURLs), ctx, userID)
 }
 
+// Update mocks base method.
+func (m *MockStorage) Update(ctx context.Context, userID string, url *model.URL) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, userID, url)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockStorageMockRecorder) Update(ctx, userID, url interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockStorage)(nil).Update), ctx, userID, url)
+}
+
 // MockDatabase is a mock of Database interface.
 type MockDatabase struct {
 	ctrl     *gomock.Controller
@@ -130,6 +145,34 @@ func (mr *MockDatabaseMockRecorder) Add(ctx, userID, url interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockDatabase)(nil).Add), ctx, userID, url)
 }
 
+// AddAPIKey mocks base method.
+func (m *MockDatabase) AddAPIKey(ctx context.Context, key *model.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddAPIKey", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddAPIKey indicates an expected call of AddAPIKey.
+func (mr *MockDatabaseMockRecorder) AddAPIKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddAPIKey", reflect.TypeOf((*MockDatabase)(nil).AddAPIKey), ctx, key)
+}
+
+// AddWithQuota mocks base method.
+func (m *MockDatabase) AddWithQuota(ctx context.Context, userID string, url *model.URL, limit int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddWithQuota", ctx, userID, url, limit)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddWithQuota indicates an expected call of AddWithQuota.
+func (mr *MockDatabaseMockRecorder) AddWithQuota(ctx, userID, url, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddWithQuota", reflect.TypeOf((*MockDatabase)(nil).AddWithQuota), ctx, userID, url, limit)
+}
+
 // Close mocks base method.
 func (m *MockDatabase) Close() error {
 	m.ctrl.T.Helper()
@@ -144,6 +187,35 @@ func (mr *MockDatabaseMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockDatabase)(nil).Close))
 }
 
+// CountUserURLs mocks base method.
+func (m *MockDatabase) CountUserURLs(ctx context.Context, userID string, now time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountUserURLs", ctx, userID, now)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountUserURLs indicates an expected call of CountUserURLs.
+func (mr *MockDatabaseMockRecorder) CountUserURLs(ctx, userID, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountUserURLs", reflect.TypeOf((*MockDatabase)(nil).CountUserURLs), ctx, userID, now)
+}
+
+// CreateUser mocks base method.
+func (m *MockDatabase) CreateUser(ctx context.Context, user *model.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUser", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUser indicates an expected call of CreateUser.
+func (mr *MockDatabaseMockRecorder) CreateUser(ctx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockDatabase)(nil).CreateUser), ctx, user)
+}
+
 // DeleteBatch mocks base method.
 func (m *MockDatabase) DeleteBatch(ctx context.Context, toDelete []model.DeleteUserURLs) error {
 	m.ctrl.T.Helper()
@@ -158,6 +230,36 @@ func (mr *MockDatabaseMockRecorder) DeleteBatch(ctx, toDelete interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBatch", reflect.TypeOf((*MockDatabase)(nil).DeleteBatch), ctx, toDelete)
 }
 
+// GetAPIKeyByHash mocks base method.
+func (m *MockDatabase) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeyByHash", ctx, hash)
+	ret0, _ := ret[0].(*model.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeyByHash indicates an expected call of GetAPIKeyByHash.
+func (mr *MockDatabaseMockRecorder) GetAPIKeyByHash(ctx, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByHash", reflect.TypeOf((*MockDatabase)(nil).GetAPIKeyByHash), ctx, hash)
+}
+
+// GetAPIKeys mocks base method.
+func (m *MockDatabase) GetAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeys", ctx, userID)
+	ret0, _ := ret[0].([]*model.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeys indicates an expected call of GetAPIKeys.
+func (mr *MockDatabaseMockRecorder) GetAPIKeys(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeys", reflect.TypeOf((*MockDatabase)(nil).GetAPIKeys), ctx, userID)
+}
+
 // GetByID mocks base method.
 func (m *MockDatabase) GetByID(ctx context.Context, id string) (*model.URL, error) {
 	m.ctrl.T.Helper()
@@ -173,6 +275,21 @@ func (mr *MockDatabaseMockRecorder) GetByID(ctx, id interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockDatabase)(nil).GetByID), ctx, id)
 }
 
+// GetClickStats mocks base method.
+func (m *MockDatabase) GetClickStats(ctx context.Context, userID, shortURL string) (*model.ClickStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClickStats", ctx, userID, shortURL)
+	ret0, _ := ret[0].(*model.ClickStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClickStats indicates an expected call of GetClickStats.
+func (mr *MockDatabaseMockRecorder) GetClickStats(ctx, userID, shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClickStats", reflect.TypeOf((*MockDatabase)(nil).GetClickStats), ctx, userID, shortURL)
+}
+
 // GetNumberOfURLs mocks base method.
 func (m *MockDatabase) GetNumberOfURLs(ctx context.Context) (int, error) {
 	m.ctrl.T.Helper()
@@ -203,6 +320,21 @@ func (mr *MockDatabaseMockRecorder) GetNumberOfUsers(ctx interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNumberOfUsers", reflect.TypeOf((*MockDatabase)(nil).GetNumberOfUsers), ctx)
 }
 
+// GetUser mocks base method.
+func (m *MockDatabase) GetUser(ctx context.Context, username string) (*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUser", ctx, username)
+	ret0, _ := ret[0].(*model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUser indicates an expected call of GetUser.
+func (mr *MockDatabaseMockRecorder) GetUser(ctx, username interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockDatabase)(nil).GetUser), ctx, username)
+}
+
 // GetUserURLs mocks base method.
 func (m *MockDatabase) GetUserURLs(ctx context.Context, userID string) ([]*model.URL, error) {
 	m.ctrl.T.Helper()
@@ -218,6 +350,81 @@ func (mr *MockDatabaseMockRecorder) GetUserURLs(ctx, userID interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLs", reflect.TypeOf((*MockDatabase)(nil).GetUserURLs), ctx, userID)
 }
 
+// IsRegisteredUser mocks base method.
+func (m *MockDatabase) IsRegisteredUser(ctx context.Context, userID string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsRegisteredUser", ctx, userID)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsRegisteredUser indicates an expected call of IsRegisteredUser.
+func (mr *MockDatabaseMockRecorder) IsRegisteredUser(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsRegisteredUser", reflect.TypeOf((*MockDatabase)(nil).IsRegisteredUser), ctx, userID)
+}
+
+// MarkExpired mocks base method.
+func (m *MockDatabase) MarkExpired(ctx context.Context, now time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkExpired", ctx, now)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkExpired indicates an expected call of MarkExpired.
+func (mr *MockDatabaseMockRecorder) MarkExpired(ctx, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkExpired", reflect.TypeOf((*MockDatabase)(nil).MarkExpired), ctx, now)
+}
+
+// MergeUserURLs mocks base method.
+func (m *MockDatabase) MergeUserURLs(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergeUserURLs", ctx, fromUserID, toUserID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MergeUserURLs indicates an expected call of MergeUserURLs.
+func (mr *MockDatabaseMockRecorder) MergeUserURLs(ctx, fromUserID, toUserID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeUserURLs", reflect.TypeOf((*MockDatabase)(nil).MergeUserURLs), ctx, fromUserID, toUserID)
+}
+
+// MergeUserURLsWithQuota mocks base method.
+func (m *MockDatabase) MergeUserURLsWithQuota(ctx context.Context, fromUserID, toUserID string, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergeUserURLsWithQuota", ctx, fromUserID, toUserID, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MergeUserURLsWithQuota indicates an expected call of MergeUserURLsWithQuota.
+func (mr *MockDatabaseMockRecorder) MergeUserURLsWithQuota(ctx, fromUserID, toUserID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeUserURLsWithQuota", reflect.TypeOf((*MockDatabase)(nil).MergeUserURLsWithQuota), ctx, fromUserID, toUserID, limit)
+}
+
+// NextSequence mocks base method.
+func (m *MockDatabase) NextSequence(ctx context.Context, n int) ([]uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextSequence", ctx, n)
+	ret0, _ := ret[0].([]uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NextSequence indicates an expected call of NextSequence.
+func (mr *MockDatabaseMockRecorder) NextSequence(ctx, n interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextSequence", reflect.TypeOf((*MockDatabase)(nil).NextSequence), ctx, n)
+}
+
 // Ping mocks base method.
 func (m *MockDatabase) Ping(ctx context.Context) error {
 	m.ctrl.T.Helper()
@@ -232,6 +439,63 @@ func (mr *MockDatabaseMockRecorder) Ping(ctx interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockDatabase)(nil).Ping), ctx)
 }
 
+// ReleaseKeys mocks base method.
+func (m *MockDatabase) ReleaseKeys(ctx context.Context, keys []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseKeys", ctx, keys)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseKeys indicates an expected call of ReleaseKeys.
+func (mr *MockDatabaseMockRecorder) ReleaseKeys(ctx, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseKeys", reflect.TypeOf((*MockDatabase)(nil).ReleaseKeys), ctx, keys)
+}
+
+// ReserveKeys mocks base method.
+func (m *MockDatabase) ReserveKeys(ctx context.Context, keys []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveKeys", ctx, keys)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReserveKeys indicates an expected call of ReserveKeys.
+func (mr *MockDatabaseMockRecorder) ReserveKeys(ctx, keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveKeys", reflect.TypeOf((*MockDatabase)(nil).ReserveKeys), ctx, keys)
+}
+
+// RevokeAPIKey mocks base method.
+func (m *MockDatabase) RevokeAPIKey(ctx context.Context, userID, id string, revokedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAPIKey", ctx, userID, id, revokedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAPIKey indicates an expected call of RevokeAPIKey.
+func (mr *MockDatabaseMockRecorder) RevokeAPIKey(ctx, userID, id, revokedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAPIKey", reflect.TypeOf((*MockDatabase)(nil).RevokeAPIKey), ctx, userID, id, revokedAt)
+}
+
+// Update mocks base method.
+func (m *MockDatabase) Update(ctx context.Context, userID string, url *model.URL) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, userID, url)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockDatabaseMockRecorder) Update(ctx, userID, url interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDatabase)(nil).Update), ctx, userID, url)
+}
+
 // WriteBatch mocks base method.
 func (m *MockDatabase) WriteBatch(ctx context.Context, userID string, urls map[string]*model.URL) error {
 	m.ctrl.T.Helper()
@@ -246,6 +510,34 @@ func (mr *MockDatabaseMockRecorder) WriteBatch(ctx, userID, urls interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteBatch", reflect.TypeOf((*MockDatabase)(nil).WriteBatch), ctx, userID, urls)
 }
 
+// WriteBatchWithQuota mocks base method.
+func (m *MockDatabase) WriteBatchWithQuota(ctx context.Context, userID string, urls map[string]*model.URL, limit int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteBatchWithQuota", ctx, userID, urls, limit)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteBatchWithQuota indicates an expected call of WriteBatchWithQuota.
+func (mr *MockDatabaseMockRecorder) WriteBatchWithQuota(ctx, userID, urls, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteBatchWithQuota", reflect.TypeOf((*MockDatabase)(nil).WriteBatchWithQuota), ctx, userID, urls, limit)
+}
+
+// WriteClicks mocks base method.
+func (m *MockDatabase) WriteClicks(ctx context.Context, clicks []model.Click) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteClicks", ctx, clicks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteClicks indicates an expected call of WriteClicks.
+func (mr *MockDatabaseMockRecorder) WriteClicks(ctx, clicks interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteClicks", reflect.TypeOf((*MockDatabase)(nil).WriteClicks), ctx, clicks)
+}
+
 // MockIGenerator is a mock of IGenerator interface.
 type MockIGenerator struct {
 	ctrl     *gomock.Controller
@@ -282,3 +574,77 @@ func (mr *MockIGeneratorMockRecorder) MakeShortURL() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeShortURL", reflect.TypeOf((*MockIGenerator)(nil).MakeShortURL))
 }
+
+// MockICacheStats is a mock of ICacheStats interface.
+type MockICacheStats struct {
+	ctrl     *gomock.Controller
+	recorder *MockICacheStatsMockRecorder
+}
+
+// MockICacheStatsMockRecorder is the mock recorder for MockICacheStats.
+type MockICacheStatsMockRecorder struct {
+	mock *MockICacheStats
+}
+
+// NewMockICacheStats creates a new mock instance.
+func NewMockICacheStats(ctrl *gomock.Controller) *MockICacheStats {
+	mock := &MockICacheStats{ctrl: ctrl}
+	mock.recorder = &MockICacheStatsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockICacheStats) EXPECT() *MockICacheStatsMockRecorder {
+	return m.recorder
+}
+
+// CacheStats mocks base method.
+func (m *MockICacheStats) CacheStats() model.CacheStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CacheStats")
+	ret0, _ := ret[0].(model.CacheStats)
+	return ret0
+}
+
+// CacheStats indicates an expected call of CacheStats.
+func (mr *MockICacheStatsMockRecorder) CacheStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CacheStats", reflect.TypeOf((*MockICacheStats)(nil).CacheStats))
+}
+
+// MockIDomainPolicy is a mock of IDomainPolicy interface.
+type MockIDomainPolicy struct {
+	ctrl     *gomock.Controller
+	recorder *MockIDomainPolicyMockRecorder
+}
+
+// MockIDomainPolicyMockRecorder is the mock recorder for MockIDomainPolicy.
+type MockIDomainPolicyMockRecorder struct {
+	mock *MockIDomainPolicy
+}
+
+// NewMockIDomainPolicy creates a new mock instance.
+func NewMockIDomainPolicy(ctrl *gomock.Controller) *MockIDomainPolicy {
+	mock := &MockIDomainPolicy{ctrl: ctrl}
+	mock.recorder = &MockIDomainPolicyMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIDomainPolicy) EXPECT() *MockIDomainPolicyMockRecorder {
+	return m.recorder
+}
+
+// IsBlocked mocks base method.
+func (m *MockIDomainPolicy) IsBlocked(host string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsBlocked", host)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsBlocked indicates an expected call of IsBlocked.
+func (mr *MockIDomainPolicyMockRecorder) IsBlocked(host interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsBlocked", reflect.TypeOf((*MockIDomainPolicy)(nil).IsBlocked), host)
+}
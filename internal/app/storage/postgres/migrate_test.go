@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, "versions must go in a row")
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		wantErr  bool
+	}{
+		{
+			name: "sorted_by_version",
+			files: fstest.MapFS{
+				"migrations/0010_b.up.sql":   {Data: []byte("b")},
+				"migrations/0010_b.down.sql": {Data: []byte("b")},
+				"migrations/0002_a.up.sql":   {Data: []byte("a")},
+				"migrations/0002_a.down.sql": {Data: []byte("a")},
+			},
+			versions: []int{2, 10},
+		},
+		{
+			name: "missing_down",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql": {Data: []byte("a")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong_name",
+			files: fstest.MapFS{
+				"migrations/init.up.sql":   {Data: []byte("a")},
+				"migrations/init.down.sql": {Data: []byte("a")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate_version",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql":   {Data: []byte("a")},
+				"migrations/0001_a.down.sql": {Data: []byte("a")},
+				"migrations/0001_b.up.sql":   {Data: []byte("b")},
+				"migrations/0001_b.down.sql": {Data: []byte("b")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			versions := make([]int, 0, len(migrations))
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, tt.versions, versions)
+		})
+	}
+}
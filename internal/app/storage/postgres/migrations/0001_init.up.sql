@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.users(
+    user_id VARCHAR(500) NOT NULL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS public.urls(
+    short VARCHAR(50) NOT NULL PRIMARY KEY,
+    origin VARCHAR(500) NOT NULL,
+    user_id VARCHAR(500) NOT NULL,
+    deleted BOOLEAN DEFAULT FALSE NOT NULL,
+    CONSTRAINT uniq_origin_user_id UNIQUE (origin, user_id),
+    FOREIGN KEY (user_id) REFERENCES public.users (user_id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE public.urls DROP COLUMN IF EXISTS expired;
+ALTER TABLE public.urls DROP COLUMN IF EXISTS expires_at;
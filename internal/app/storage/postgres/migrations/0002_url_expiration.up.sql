@@ -0,0 +1,2 @@
+ALTER TABLE public.urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL;
+ALTER TABLE public.urls ADD COLUMN IF NOT EXISTS expired BOOLEAN DEFAULT FALSE NOT NULL;
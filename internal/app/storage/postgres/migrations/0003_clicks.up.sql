@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS public.clicks(
+    id BIGSERIAL PRIMARY KEY,
+    short VARCHAR(50) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    FOREIGN KEY (short) REFERENCES public.urls (short)
+);
+
+CREATE INDEX IF NOT EXISTS clicks_short_idx ON public.clicks (short);
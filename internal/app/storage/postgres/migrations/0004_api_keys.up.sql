@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS public.api_keys(
+    id VARCHAR(50) NOT NULL PRIMARY KEY,
+    user_id VARCHAR(500) NOT NULL,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    key_hash VARCHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    CONSTRAINT uniq_api_key_hash UNIQUE (key_hash),
+    FOREIGN KEY (user_id) REFERENCES public.users (user_id)
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON public.api_keys (user_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.users_username_idx;
+
+ALTER TABLE public.users DROP COLUMN IF EXISTS created_at;
+ALTER TABLE public.users DROP COLUMN IF EXISTS password_hash;
+ALTER TABLE public.users DROP COLUMN IF EXISTS username;
@@ -0,0 +1,5 @@
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS username VARCHAR(50);
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS password_hash VARCHAR(100);
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_idx ON public.users (username);
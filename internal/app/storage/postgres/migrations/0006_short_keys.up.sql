@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS public.short_keys(
+    short VARCHAR(50) NOT NULL PRIMARY KEY,
+    reserved_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1 @@
+DROP SEQUENCE IF EXISTS public.short_urls_seq;
@@ -0,0 +1 @@
+CREATE SEQUENCE IF NOT EXISTS public.short_urls_seq;
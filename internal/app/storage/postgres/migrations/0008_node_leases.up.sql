@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS public.node_leases(
+    node_id INTEGER NOT NULL PRIMARY KEY,
+    owner VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
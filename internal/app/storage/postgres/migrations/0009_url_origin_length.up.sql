@@ -0,0 +1 @@
+ALTER TABLE public.urls ALTER COLUMN origin TYPE VARCHAR(2048);
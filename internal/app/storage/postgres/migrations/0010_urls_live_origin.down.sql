@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.uniq_origin_user_id;
+ALTER TABLE public.urls ADD CONSTRAINT uniq_origin_user_id UNIQUE (origin, user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE public.urls DROP CONSTRAINT IF EXISTS uniq_origin_user_id;
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_origin_user_id ON public.urls (origin, user_id) WHERE NOT deleted;
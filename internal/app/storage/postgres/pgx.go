@@ -3,13 +3,26 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/kotche/url-shortening-service/internal/app/config"
 	"github.com/kotche/url-shortening-service/internal/app/model"
 )
 
+const (
+	uniqueViolation = "23505"
+	urlsPrimaryKey  = "urls_pkey"
+	urlsUniqOrigin  = "uniq_origin_user_id"
+	usersUniqName   = "users_username_idx"
+	scopesSeparator = ","
+)
+
 type DB struct {
 	conn *sql.DB
 }
@@ -19,29 +32,119 @@ func NewDB(DSN string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	db := &DB{conn: conn}
-	db.init()
-	return db, nil
+	migrator, err := newMigrator(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = migrator.Up(context.Background()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &DB{conn: conn}, nil
+}
+
+// queryer is the connection or the transaction
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 func (d *DB) Add(ctx context.Context, userID string, url *model.URL) error {
-	_, err := d.conn.ExecContext(ctx,
+	return addURL(ctx, d.conn, userID, url)
+}
+
+// AddWithQuota adds the link if the user has less than limit live links. The user's link of the same origin
+// is a conflict, not a new link, so it is reported regardless of the quota
+func (d *DB) AddWithQuota(ctx context.Context, userID string, url *model.URL, limit int) error {
+	return d.withQuota(ctx, userID, 1, limit, func(tx *sql.Tx) error {
+		var short string
+		row := tx.QueryRowContext(ctx, "SELECT short FROM public.urls WHERE origin=$1 AND user_id=$2 AND NOT deleted", url.Origin, userID)
+		err := row.Scan(&short)
+		if err == nil {
+			return model.ConflictURLError{ShortenURL: short}
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return nil
+	}, func(tx *sql.Tx) error {
+		return addURL(ctx, tx, userID, url)
+	})
+}
+
+// WriteBatchWithQuota writes the links if all of them fit into the user's limit of live links
+func (d *DB) WriteBatchWithQuota(ctx context.Context, userID string, urls map[string]*model.URL, limit int) error {
+	return d.withQuota(ctx, userID, len(urls), limit, nil, func(tx *sql.Tx) error {
+		return writeURLs(ctx, tx, userID, urls)
+	})
+}
+
+// withQuota counts the user's live links and writes n more in one transaction. The user row is locked,
+// so the writes of the user wait for each other, also on the other instances, while the others go on.
+// The optional check runs under the lock before the quota
+func (d *DB) withQuota(ctx context.Context, userID string, n int, limit int, check func(tx *sql.Tx) error,
+	write func(tx *sql.Tx) error) error {
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO public.users(user_id) VALUES ($1) ON CONFLICT (user_id) DO UPDATE SET user_id=EXCLUDED.user_id;", userID)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "SELECT user_id FROM public.users WHERE user_id=$1 FOR UPDATE", userID); err != nil {
+		return err
+	}
+
+	if check != nil {
+		if err = check(tx); err != nil {
+			return err
+		}
+	}
+
+	used, err := countUserURLs(ctx, tx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if used+n > limit {
+		return model.QuotaExceededError{Limit: limit}
+	}
+
+	if err = write(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addURL(ctx context.Context, q queryer, userID string, url *model.URL) error {
+	_, err := q.ExecContext(ctx,
 		"INSERT INTO public.users(user_id) VALUES ($1) ON CONFLICT (user_id) DO UPDATE SET user_id=EXCLUDED.user_id;", userID)
 	if err != nil {
 		return err
 	}
 
-	stmt, err := d.conn.PrepareContext(ctx,
-		"INSERT INTO public.urls(short,origin,user_id) VALUES ($1,$2,$3) ON CONFLICT (origin,user_id) DO UPDATE SET origin=EXCLUDED.origin RETURNING short")
+	stmt, err := q.PrepareContext(ctx,
+		"WITH used AS (DELETE FROM public.short_keys WHERE short=$1) "+
+			"INSERT INTO public.urls(short,origin,user_id,expires_at) VALUES ($1,$2,$3,$4) ON CONFLICT (origin,user_id) WHERE NOT deleted DO UPDATE SET origin=EXCLUDED.origin RETURNING short")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	result := stmt.QueryRowContext(ctx, url.Short, url.Origin, userID)
+	result := stmt.QueryRowContext(ctx, url.Short, url.Origin, userID, url.ExpiresAt)
 
 	var output string
-	result.Scan(&output)
+	err = result.Scan(&output)
+	if isShortConflict(err) {
+		return model.AliasConflictError{Alias: url.Short}
+	} else if err != nil {
+		return err
+	}
 	if output != url.Short {
 		return model.ConflictURLError{ShortenURL: output}
 	}
@@ -51,40 +154,56 @@ func (d *DB) Add(ctx context.Context, userID string, url *model.URL) error {
 
 func (d *DB) GetByID(ctx context.Context, id string) (*model.URL, error) {
 	var (
-		output  string
-		deleted bool
+		output    string
+		deleted   bool
+		expired   bool
+		expiresAt sql.NullTime
 	)
 
-	row := d.conn.QueryRowContext(ctx, "SELECT origin,deleted FROM public.urls WHERE short=$1", id)
-	row.Scan(&output, &deleted)
+	row := d.conn.QueryRowContext(ctx, "SELECT origin,deleted,expired,expires_at FROM public.urls WHERE short=$1", id)
+	err := row.Scan(&output, &deleted, &expired, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.NotFoundError{ShortenURL: id}
+	} else if err != nil {
+		return nil, err
+	}
 
 	if deleted {
 		return nil, model.GoneError{ShortenURL: output}
 	}
 
-	if output != "" {
-		url := model.NewURL(output, id)
-		return url, nil
-	} else {
-		return nil, fmt.Errorf("key not found")
+	if expired {
+		return nil, model.ExpiredError{ShortenURL: id}
 	}
+
+	url := model.NewURL(output, id)
+	if expiresAt.Valid {
+		url.ExpiresAt = &expiresAt.Time
+	}
+	return url, nil
 }
 
 func (d *DB) GetUserURLs(ctx context.Context, userID string) ([]*model.URL, error) {
 	urls := make([]*model.URL, 0)
 
-	rows, err := d.conn.QueryContext(ctx, "SELECT short, origin FROM public.urls WHERE user_id=$1", userID)
+	rows, err := d.conn.QueryContext(ctx, "SELECT short, origin, expires_at FROM public.urls WHERE user_id=$1", userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var url model.URL
-		err = rows.Scan(&url.Short, &url.Origin)
+		var (
+			url       model.URL
+			expiresAt sql.NullTime
+		)
+		err = rows.Scan(&url.Short, &url.Origin, &expiresAt)
 		if err != nil {
 			return nil, err
 		}
+		if expiresAt.Valid {
+			url.ExpiresAt = &expiresAt.Time
+		}
 		urls = append(urls, &url)
 	}
 
@@ -96,6 +215,36 @@ func (d *DB) GetUserURLs(ctx context.Context, userID string) ([]*model.URL, erro
 	return urls, nil
 }
 
+func (d *DB) Update(ctx context.Context, userID string, url *model.URL) error {
+	var expiresAt sql.NullTime
+	row := d.conn.QueryRowContext(ctx,
+		"UPDATE public.urls SET origin=$1 WHERE user_id=$2 AND short=$3 AND NOT deleted RETURNING expires_at",
+		url.Origin, userID, url.Short)
+
+	err := row.Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.NotFoundError{ShortenURL: url.Short}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == urlsUniqOrigin {
+		var short string
+		row = d.conn.QueryRowContext(ctx,
+			"SELECT short FROM public.urls WHERE origin=$1 AND user_id=$2 AND NOT deleted", url.Origin, userID)
+		if err = row.Scan(&short); err != nil {
+			return err
+		}
+		return model.ConflictURLError{ShortenURL: short}
+	} else if err != nil {
+		return err
+	}
+
+	if expiresAt.Valid {
+		url.ExpiresAt = &expiresAt.Time
+	}
+	return nil
+}
+
 func (d *DB) Close() error {
 	if err := d.conn.Close(); err != nil {
 		return err
@@ -123,22 +272,30 @@ func (d *DB) WriteBatch(ctx context.Context, userID string, urls map[string]*mod
 		return err
 	}
 
+	if err = writeURLs(ctx, tx, userID, urls); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func writeURLs(ctx context.Context, tx *sql.Tx, userID string, urls map[string]*model.URL) error {
 	stmt, err := tx.PrepareContext(ctx,
-		"INSERT INTO public.urls(short,origin,user_id) VALUES ($1,$2,$3)")
+		"WITH used AS (DELETE FROM public.short_keys WHERE short=$1) INSERT INTO public.urls(short,origin,user_id,expires_at) VALUES ($1,$2,$3,$4)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, url := range urls {
-		_, err = stmt.ExecContext(ctx, url.Short, url.Origin, userID)
-		if err != nil {
+		_, err = stmt.ExecContext(ctx, url.Short, url.Origin, userID, url.ExpiresAt)
+		if isShortConflict(err) {
+			return model.AliasConflictError{Alias: url.Short}
+		} else if err != nil {
 			log.Println(err.Error())
 			return err
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 func (d *DB) DeleteBatch(ctx context.Context, toDelete []model.DeleteUserURLs) error {
@@ -167,6 +324,428 @@ func (d *DB) DeleteBatch(ctx context.Context, toDelete []model.DeleteUserURLs) e
 	return tx.Commit()
 }
 
+func (d *DB) MarkExpired(ctx context.Context, now time.Time) (int, error) {
+	result, err := d.conn.ExecContext(ctx,
+		"UPDATE public.urls SET expired=true WHERE expires_at<=$1 AND NOT expired AND NOT deleted", now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// ReserveKeys returns the keys that are neither used nor reserved before and reserves them in the short_keys table.
+// The table is shared by the instances, so every key is given out once. The key is deleted from the table when
+// the link is added with it. The reservations older than the timeout are left by the crashed instances and
+// are reclaimed: even if such a key is still used, the insert conflicts and the next key is taken
+func (d *DB) ReserveKeys(ctx context.Context, keys []string) ([]string, error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "DELETE FROM public.short_keys WHERE reserved_at < $1",
+		now.Add(-time.Second*config.KeyReserveTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO public.short_keys(short,reserved_at) SELECT $1,$2 WHERE NOT EXISTS (SELECT 1 FROM public.urls WHERE short=$1) ON CONFLICT (short) DO NOTHING RETURNING short")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	reserved := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var short string
+		err = stmt.QueryRowContext(ctx, key, now).Scan(&short)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		reserved = append(reserved, short)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return reserved, nil
+}
+
+// ReleaseKeys deletes the reservations of the unused keys, e.g. of the pool on shutdown
+func (d *DB) ReleaseKeys(ctx context.Context, keys []string) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM public.short_keys WHERE short=$1")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, key := range keys {
+		if _, err = stmt.ExecContext(ctx, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// NextSequence returns the next n values of the short_urls sequence. The values are unique across the instances,
+// but not necessarily consecutive
+func (d *DB) NextSequence(ctx context.Context, n int) ([]uint64, error) {
+	rows, err := d.conn.QueryContext(ctx, "SELECT nextval('public.short_urls_seq') FROM generate_series(1,$1)", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]uint64, 0, n)
+	for rows.Next() {
+		var value int64
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, uint64(value))
+	}
+	return values, rows.Err()
+}
+
+// LeaseNode leases the first node ID that is free or whose lease has expired. The table is locked,
+// so the instances starting at the same time get the different nodes
+func (d *DB) LeaseNode(ctx context.Context, owner string, maxNode int64, ttl time.Duration) (int64, error) {
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "LOCK TABLE public.node_leases IN EXCLUSIVE MODE"); err != nil {
+		return 0, err
+	}
+
+	var node int64
+	err = tx.QueryRowContext(ctx,
+		"SELECT n FROM generate_series(0,$1) n WHERE NOT EXISTS (SELECT 1 FROM public.node_leases WHERE node_id=n AND expires_at>now()) ORDER BY n LIMIT 1",
+		maxNode).Scan(&node)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("all %d node IDs are leased", maxNode+1)
+	} else if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO public.node_leases(node_id,owner,expires_at) VALUES ($1,$2,now()+make_interval(secs => $3)) ON CONFLICT (node_id) DO UPDATE SET owner=EXCLUDED.owner, expires_at=EXCLUDED.expires_at",
+		node, owner, ttl.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return node, nil
+}
+
+// RenewNode extends the lease of the node, it fails if the lease has been taken by another owner
+func (d *DB) RenewNode(ctx context.Context, node int64, owner string, ttl time.Duration) error {
+	result, err := d.conn.ExecContext(ctx,
+		"UPDATE public.node_leases SET expires_at=now()+make_interval(secs => $3) WHERE node_id=$1 AND owner=$2",
+		node, owner, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("node %d is leased by another owner", node)
+	}
+	return nil
+}
+
+// ReleaseNode frees the node for the other instances
+func (d *DB) ReleaseNode(ctx context.Context, node int64, owner string) error {
+	_, err := d.conn.ExecContext(ctx, "DELETE FROM public.node_leases WHERE node_id=$1 AND owner=$2", node, owner)
+	return err
+}
+
+func (d *DB) WriteClicks(ctx context.Context, clicks []model.Click) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO public.clicks(short,clicked_at,referrer,user_agent,ip) VALUES ($1,$2,$3,$4,$5)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.Short, click.Time, click.Referrer, click.UserAgent, click.IP)
+		if err != nil {
+			log.Println(err.Error())
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *DB) GetClickStats(ctx context.Context, userID string, shortURL string) (*model.ClickStats, error) {
+	var isOwner bool
+	row := d.conn.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM public.urls WHERE short=$1 AND user_id=$2)", shortURL, userID)
+	if err := row.Scan(&isOwner); err != nil {
+		return nil, err
+	}
+	if !isOwner {
+		return nil, model.NotFoundError{ShortenURL: shortURL}
+	}
+
+	stats := &model.ClickStats{Days: make([]model.DayClicks, 0)}
+	row = d.conn.QueryRowContext(ctx,
+		"SELECT COUNT(*), COUNT(DISTINCT ip) FROM public.clicks WHERE short=$1", shortURL)
+	if err := row.Scan(&stats.TotalClicks, &stats.UniqueVisitors); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.conn.QueryContext(ctx,
+		"SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, COUNT(*) FROM public.clicks WHERE short=$1 GROUP BY day ORDER BY day", shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day model.DayClicks
+		if err = rows.Scan(&day.Date, &day.Clicks); err != nil {
+			return nil, err
+		}
+		stats.Days = append(stats.Days, day)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (d *DB) AddAPIKey(ctx context.Context, key *model.APIKey) error {
+	_, err := d.conn.ExecContext(ctx,
+		"INSERT INTO public.users(user_id) VALUES ($1) ON CONFLICT (user_id) DO UPDATE SET user_id=EXCLUDED.user_id;", key.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.ExecContext(ctx,
+		"INSERT INTO public.api_keys(id,user_id,name,key_hash,scopes,created_at) VALUES ($1,$2,$3,$4,$5,$6)",
+		key.ID, key.UserID, key.Name, key.Hash, strings.Join(key.Scopes, scopesSeparator), key.CreatedAt)
+	return err
+}
+
+func (d *DB) GetAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error) {
+	keys := make([]*model.APIKey, 0)
+
+	rows, err := d.conn.QueryContext(ctx,
+		"SELECT id,user_id,name,key_hash,scopes,created_at,revoked_at FROM public.api_keys WHERE user_id=$1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (d *DB) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	row := d.conn.QueryRowContext(ctx,
+		"SELECT id,user_id,name,key_hash,scopes,created_at,revoked_at FROM public.api_keys WHERE key_hash=$1", hash)
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.InvalidAPIKeyError{}
+	} else if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (d *DB) RevokeAPIKey(ctx context.Context, userID string, id string, revokedAt time.Time) error {
+	var exists bool
+	row := d.conn.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM public.api_keys WHERE id=$1 AND user_id=$2)", id, userID)
+	if err := row.Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return model.APIKeyNotFoundError{ID: id}
+	}
+
+	_, err := d.conn.ExecContext(ctx,
+		"UPDATE public.api_keys SET revoked_at=$1 WHERE id=$2 AND user_id=$3 AND revoked_at IS NULL", revokedAt, id, userID)
+	return err
+}
+
+// CreateUser registers the account. The anonymous user with the same ID becomes the account
+func (d *DB) CreateUser(ctx context.Context, user *model.User) error {
+	result, err := d.conn.ExecContext(ctx,
+		"INSERT INTO public.users(user_id,username,password_hash,created_at) VALUES ($1,$2,$3,$4) "+
+			"ON CONFLICT (user_id) DO UPDATE SET username=EXCLUDED.username, password_hash=EXCLUDED.password_hash, created_at=EXCLUDED.created_at "+
+			"WHERE public.users.username IS NULL",
+		user.ID, user.Username, user.PasswordHash, user.CreatedAt)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == usersUniqName {
+		return model.UsernameTakenError{Username: user.Username}
+	} else if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("user %s is already registered", user.ID)
+	}
+	return nil
+}
+
+func (d *DB) GetUser(ctx context.Context, username string) (*model.User, error) {
+	var user model.User
+	row := d.conn.QueryRowContext(ctx,
+		"SELECT user_id,username,password_hash,created_at FROM public.users WHERE username=$1", username)
+
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.InvalidCredentialsError{}
+	} else if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (d *DB) IsRegisteredUser(ctx context.Context, userID string) (bool, error) {
+	var registered bool
+	row := d.conn.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM public.users WHERE user_id=$1 AND username IS NOT NULL)", userID)
+	if err := row.Scan(&registered); err != nil {
+		return false, err
+	}
+	return registered, nil
+}
+
+// MergeUserURLs moves the links of one user to another.
+// The links with the original URL that the target user already has are left in place
+func (d *DB) MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	result, err := d.conn.ExecContext(ctx,
+		"UPDATE public.urls SET user_id=$2 WHERE user_id=$1 AND origin NOT IN (SELECT origin FROM public.urls WHERE user_id=$2)",
+		fromUserID, toUserID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// MergeUserURLsWithQuota moves the links if the live ones fit into the target user's limit, otherwise none of them.
+// The target user row is locked as in withQuota, so the merge and the writes of the user wait for each other
+func (d *DB) MergeUserURLsWithQuota(ctx context.Context, fromUserID string, toUserID string, limit int) (int, error) {
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "SELECT user_id FROM public.users WHERE user_id=$1 FOR UPDATE", toUserID); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var n int
+	row := tx.QueryRowContext(ctx,
+		"SELECT COUNT(short) FROM public.urls WHERE user_id=$1 AND NOT deleted AND NOT expired AND (expires_at IS NULL OR expires_at>$3) "+
+			"AND origin NOT IN (SELECT origin FROM public.urls WHERE user_id=$2)",
+		fromUserID, toUserID, now)
+	if err = row.Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		used, err := countUserURLs(ctx, tx, toUserID, now)
+		if err != nil {
+			return 0, err
+		}
+		if used+n > limit {
+			return 0, model.QuotaExceededError{Limit: limit}
+		}
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE public.urls SET user_id=$2 WHERE user_id=$1 AND origin NOT IN (SELECT origin FROM public.urls WHERE user_id=$2)",
+		fromUserID, toUserID)
+	if err != nil {
+		return 0, err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(moved), tx.Commit()
+}
+
+// CountUserURLs returns the number of the user's links that are neither deleted nor expired at the specified time
+func (d *DB) CountUserURLs(ctx context.Context, userID string, now time.Time) (int, error) {
+	return countUserURLs(ctx, d.conn, userID, now)
+}
+
+func countUserURLs(ctx context.Context, q queryer, userID string, now time.Time) (int, error) {
+	var n int
+	row := q.QueryRowContext(ctx,
+		"SELECT COUNT(short) FROM public.urls WHERE user_id=$1 AND NOT deleted AND NOT expired AND (expires_at IS NULL OR expires_at>$2)",
+		userID, now)
+	if err := row.Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func (d *DB) GetNumberOfURLs(ctx context.Context) (int, error) {
 	var numberOfURLs int
 	row := d.conn.QueryRowContext(ctx, "SELECT COUNT(short) FROM urls")
@@ -187,20 +766,30 @@ func (d *DB) GetNumberOfUsers(ctx context.Context) (int, error) {
 	return numberOfUsers, nil
 }
 
-func (d *DB) init() {
-	_, err := d.conn.Exec(`CREATE TABLE IF NOT EXISTS public.users(
-		    user_id VARCHAR(500) NOT NULL PRIMARY KEY
-		);
-
-		CREATE TABLE IF NOT EXISTS public.urls(
-		short VARCHAR(50) NOT NULL PRIMARY KEY,
-		origin VARCHAR(500) NOT NULL,
-		user_id VARCHAR(500) NOT NULL,
-		deleted BOOLEAN DEFAULT FALSE NOT NULL,
-    	CONSTRAINT uniq_origin_user_id UNIQUE (origin, user_id),
-    	FOREIGN KEY (user_id) REFERENCES public.users (user_id));`)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+// scanAPIKey reads the key from the row: id,user_id,name,key_hash,scopes,created_at,revoked_at
+func scanAPIKey(row rowScanner) (*model.APIKey, error) {
+	var (
+		key       model.APIKey
+		scopes    string
+		revokedAt sql.NullTime
+	)
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &scopes, &key.CreatedAt, &revokedAt)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
+	}
+	key.Scopes = strings.Split(scopes, scopesSeparator)
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
 	}
+	return &key, nil
+}
+
+// isShortConflict checks whether the error is caused by an already taken shortened URL
+func isShortConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == urlsPrimaryKey
 }
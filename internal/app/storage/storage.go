@@ -3,44 +3,540 @@ package storage
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/kotche/url-shortening-service/internal/app/config"
 	"github.com/kotche/url-shortening-service/internal/app/model"
 )
 
-// URLStorage store the URL in RAM
+// URLStorage store the URL in RAM. Safe for concurrent use
 type URLStorage struct {
+	mu        sync.RWMutex
 	urls      map[string]*model.URL
 	urlsUsers map[string][]*model.URL
+	owners    map[string]string
+	deleted   map[string]bool
+	expired   map[string]bool
+	clicks    map[string][]model.Click
+	apiKeys   map[string]*model.APIKey
+	keyHashes map[string]string
+	users     map[string]*model.User
+	accounts  map[string]bool
+	reserved  map[string]bool
+	sequence  uint64
 }
 
 func NewUrls() *URLStorage {
 	return &URLStorage{
 		urls:      make(map[string]*model.URL),
 		urlsUsers: make(map[string][]*model.URL),
+		owners:    make(map[string]string),
+		deleted:   make(map[string]bool),
+		expired:   make(map[string]bool),
+		clicks:    make(map[string][]model.Click),
+		apiKeys:   make(map[string]*model.APIKey),
+		keyHashes: make(map[string]string),
+		users:     make(map[string]*model.User),
+		accounts:  make(map[string]bool),
+		reserved:  make(map[string]bool),
 	}
 }
 
+// Add returns AliasConflictError if the shortened URL is already taken, e.g. the key of the pool by the alias
 func (m *URLStorage) Add(_ context.Context, userID string, url *model.URL) error {
-	m.urls[url.Short] = url
-	m.urlsUsers[userID] = append(m.urlsUsers[userID], url)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAdd(userID, url); err != nil {
+		return err
+	}
+	m.add(userID, url)
 	return nil
 }
 
 func (m *URLStorage) GetByID(_ context.Context, id string) (*model.URL, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
 	original, ok := m.urls[id]
 	if !ok {
-		return nil, fmt.Errorf("key not found")
+		return nil, model.NotFoundError{ShortenURL: id}
+	}
+
+	if m.deleted[id] {
+		return nil, model.GoneError{ShortenURL: original.Origin}
+	}
+
+	if m.expired[id] {
+		return nil, model.ExpiredError{ShortenURL: id}
 	}
 
 	return original, nil
 }
 
+// GetUserURLs returns a copy of the user's list so that it can be read without holding the lock
 func (m *URLStorage) GetUserURLs(_ context.Context, userID string) ([]*model.URL, error) {
-	usersURLs := m.urlsUsers[userID]
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	usersURLs := make([]*model.URL, len(m.urlsUsers[userID]))
+	copy(usersURLs, m.urlsUsers[userID])
 	return usersURLs, nil
 }
 
+func (m *URLStorage) Update(_ context.Context, userID string, url *model.URL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(userID, url)
+}
+
 func (m *URLStorage) Close() error {
 	return nil
 }
+
+func (m *URLStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+// WriteBatch adds all URLs or none of them if at least one shortened URL is already taken
+func (m *URLStorage) WriteBatch(_ context.Context, userID string, urls map[string]*model.URL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.writeBatch(userID, urls)
+}
+
+// AddWithQuota adds the link if the user has less than limit live links, the check and the write are atomic.
+// The user's link of the same origin is a conflict, not a new link, so it is reported regardless of the quota
+func (m *URLStorage) AddWithQuota(_ context.Context, userID string, url *model.URL, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAdd(userID, url); err != nil {
+		return err
+	}
+	if err := checkQuota(m.countUserURLs(userID, time.Now()), 1, limit); err != nil {
+		return err
+	}
+	m.add(userID, url)
+	return nil
+}
+
+// WriteBatchWithQuota writes the links if all of them fit into the user's limit of live links
+func (m *URLStorage) WriteBatchWithQuota(_ context.Context, userID string, urls map[string]*model.URL, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkQuota(m.countUserURLs(userID, time.Now()), len(urls), limit); err != nil {
+		return err
+	}
+	return m.writeBatch(userID, urls)
+}
+
+func (m *URLStorage) DeleteBatch(_ context.Context, toDelete []model.DeleteUserURLs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.delete(toDelete)
+	return nil
+}
+
+func (m *URLStorage) GetNumberOfUsers(_ context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.urlsUsers), nil
+}
+
+func (m *URLStorage) GetNumberOfURLs(_ context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.urls), nil
+}
+
+func (m *URLStorage) MarkExpired(_ context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for short, url := range m.urls {
+		if !m.deleted[short] && !m.expired[short] && url.IsExpired(now) {
+			m.expired[short] = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CountUserURLs returns the number of the user's links that are neither deleted nor expired at the specified time
+func (m *URLStorage) CountUserURLs(_ context.Context, userID string, now time.Time) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.countUserURLs(userID, now), nil
+}
+
+// ReserveKeys returns the keys that are neither used nor reserved before and reserves them
+func (m *URLStorage) ReserveKeys(_ context.Context, keys []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reserved := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := m.urls[key]; ok || m.reserved[key] {
+			continue
+		}
+		m.reserved[key] = true
+		reserved = append(reserved, key)
+	}
+	return reserved, nil
+}
+
+// ReleaseKeys drops the reservations of the unused keys
+func (m *URLStorage) ReleaseKeys(_ context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.reserved, key)
+	}
+	return nil
+}
+
+// NextSequence returns the next n values of the counter, the first value is 1
+func (m *URLStorage) NextSequence(_ context.Context, n int) ([]uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.nextSequence(n), nil
+}
+
+func (m *URLStorage) WriteClicks(_ context.Context, clicks []model.Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addClicks(clicks)
+	return nil
+}
+
+func (m *URLStorage) GetClickStats(_ context.Context, userID string, shortURL string) (*model.ClickStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.owners[shortURL] != userID {
+		return nil, model.NotFoundError{ShortenURL: shortURL}
+	}
+
+	clicks := m.clicks[shortURL]
+	visitors := make(map[string]struct{})
+	days := make(map[string]int)
+	for _, click := range clicks {
+		visitors[click.IP] = struct{}{}
+		days[click.Time.UTC().Format(config.DateLayout)]++
+	}
+
+	stats := &model.ClickStats{
+		TotalClicks:    len(clicks),
+		UniqueVisitors: len(visitors),
+		Days:           make([]model.DayClicks, 0, len(days)),
+	}
+	for date, n := range days {
+		stats.Days = append(stats.Days, model.DayClicks{Date: date, Clicks: n})
+	}
+	sort.Slice(stats.Days, func(i, j int) bool {
+		return stats.Days[i].Date < stats.Days[j].Date
+	})
+
+	return stats, nil
+}
+
+func (m *URLStorage) AddAPIKey(_ context.Context, key *model.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addAPIKey(key)
+}
+
+// GetAPIKeys returns copies of the user's keys sorted by creation time
+func (m *URLStorage) GetAPIKeys(_ context.Context, userID string) ([]*model.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*model.APIKey, 0)
+	for _, key := range m.apiKeys {
+		if key.UserID == userID {
+			k := *key
+			keys = append(keys, &k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (m *URLStorage) GetAPIKeyByHash(_ context.Context, hash string) (*model.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.apiKeys[m.keyHashes[hash]]
+	if !ok {
+		return nil, model.InvalidAPIKeyError{}
+	}
+	k := *key
+	return &k, nil
+}
+
+func (m *URLStorage) RevokeAPIKey(_ context.Context, userID string, id string, revokedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revokeAPIKey(userID, id, revokedAt)
+}
+
+func (m *URLStorage) CreateUser(_ context.Context, user *model.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.createUser(user)
+}
+
+func (m *URLStorage) GetUser(_ context.Context, username string) (*model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return nil, model.InvalidCredentialsError{}
+	}
+	u := *user
+	return &u, nil
+}
+
+func (m *URLStorage) IsRegisteredUser(_ context.Context, userID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.accounts[userID], nil
+}
+
+func (m *URLStorage) MergeUserURLs(_ context.Context, fromUserID string, toUserID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.mergeUserURLs(fromUserID, toUserID), nil
+}
+
+// MergeUserURLsWithQuota moves the links if the live ones fit into the target user's limit, otherwise none of them
+func (m *URLStorage) MergeUserURLsWithQuota(_ context.Context, fromUserID string, toUserID string, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkMergeQuota(fromUserID, toUserID, limit); err != nil {
+		return 0, err
+	}
+	return m.mergeUserURLs(fromUserID, toUserID), nil
+}
+
+// canAdd is checkAdd under the read lock
+func (m *URLStorage) canAdd(userID string, url *model.URL) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.checkAdd(userID, url)
+}
+
+// checkAdd returns the conflict of the new link: the user's live link of the same origin or the taken code.
+// The caller must hold the lock
+func (m *URLStorage) checkAdd(userID string, url *model.URL) error {
+	for _, stored := range m.urlsUsers[userID] {
+		if stored.Origin == url.Origin && !m.deleted[stored.Short] {
+			return model.ConflictURLError{ShortenURL: stored.Short}
+		}
+	}
+	if _, ok := m.urls[url.Short]; ok {
+		return model.AliasConflictError{Alias: url.Short}
+	}
+	return nil
+}
+
+// nextSequence advances the counter, the caller must hold the lock
+func (m *URLStorage) nextSequence(n int) []uint64 {
+	values := make([]uint64, n)
+	for i := range values {
+		m.sequence++
+		values[i] = m.sequence
+	}
+	return values
+}
+
+// countUserURLs counts the user's live links, the caller must hold the lock
+func (m *URLStorage) countUserURLs(userID string, now time.Time) int {
+	n := 0
+	for _, url := range m.urlsUsers[userID] {
+		if !m.deleted[url.Short] && !m.expired[url.Short] && !url.IsExpired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// writeBatch adds all links or none of them if any is taken, the caller must hold the lock
+func (m *URLStorage) writeBatch(userID string, urls map[string]*model.URL) error {
+	for short := range urls {
+		if _, ok := m.urls[short]; ok {
+			return model.AliasConflictError{Alias: short}
+		}
+	}
+
+	for _, url := range urls {
+		m.add(userID, url)
+	}
+	return nil
+}
+
+// checkQuota checks that n more links fit into the limit next to the used ones
+func checkQuota(used int, n int, limit int) error {
+	if used+n > limit {
+		return model.QuotaExceededError{Limit: limit}
+	}
+	return nil
+}
+
+// add puts the URL into the indexes and drops the reservation of its key, the caller must hold the lock
+func (m *URLStorage) add(userID string, url *model.URL) {
+	delete(m.reserved, url.Short)
+	m.urls[url.Short] = url
+	m.urlsUsers[userID] = append(m.urlsUsers[userID], url)
+	m.owners[url.Short] = userID
+}
+
+// update replaces the original URL of the user's link in the indexes, the caller must hold the lock.
+// The stored models are never changed in place, so the readers can use them without the lock
+func (m *URLStorage) update(userID string, url *model.URL) error {
+	old, ok := m.urls[url.Short]
+	if !ok || m.owners[url.Short] != userID || m.deleted[url.Short] {
+		return model.NotFoundError{ShortenURL: url.Short}
+	}
+
+	updated := *old
+	updated.Origin = url.Origin
+	m.urls[url.Short] = &updated
+
+	userURLs := m.urlsUsers[userID]
+	for i := range userURLs {
+		if userURLs[i].Short == url.Short {
+			userURLs[i] = &updated
+		}
+	}
+
+	url.ExpiresAt = updated.ExpiresAt
+	return nil
+}
+
+// delete marks the user's links as deleted, the caller must hold the lock
+func (m *URLStorage) delete(toDelete []model.DeleteUserURLs) {
+	for _, url := range toDelete {
+		if owner, ok := m.owners[url.Short]; ok && owner == url.UserID {
+			m.deleted[url.Short] = true
+		}
+	}
+}
+
+// addClicks saves the clicks, the caller must hold the lock
+func (m *URLStorage) addClicks(clicks []model.Click) {
+	for _, click := range clicks {
+		m.clicks[click.Short] = append(m.clicks[click.Short], click)
+	}
+}
+
+// addAPIKey puts the key into the indexes, the caller must hold the lock
+func (m *URLStorage) addAPIKey(key *model.APIKey) error {
+	if _, ok := m.apiKeys[key.ID]; ok {
+		return fmt.Errorf("api key %s already exists", key.ID)
+	}
+	k := *key
+	m.apiKeys[key.ID] = &k
+	m.keyHashes[key.Hash] = key.ID
+	return nil
+}
+
+// revokeAPIKey marks the user's key as revoked, the caller must hold the lock.
+// The stored keys are never changed in place, the repeated revocation keeps the first time
+func (m *URLStorage) revokeAPIKey(userID string, id string, revokedAt time.Time) error {
+	key, ok := m.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return model.APIKeyNotFoundError{ID: id}
+	}
+	if key.IsRevoked() {
+		return nil
+	}
+	revoked := *key
+	revoked.RevokedAt = &revokedAt
+	m.apiKeys[id] = &revoked
+	return nil
+}
+
+// createUser puts the account into the indexes, the caller must hold the lock
+func (m *URLStorage) createUser(user *model.User) error {
+	if _, ok := m.users[user.Username]; ok {
+		return model.UsernameTakenError{Username: user.Username}
+	}
+	u := *user
+	m.users[user.Username] = &u
+	m.accounts[user.ID] = true
+	return nil
+}
+
+// mergeUserURLs moves the links of one user to another, the caller must hold the lock.
+// The links with the original URL that the target user already has are left in place
+// checkMergeQuota checks that the live links the merge moves fit into the target user's limit.
+// The merge of no live links is allowed even if the user is over the limit. The caller must hold the lock
+func (m *URLStorage) checkMergeQuota(fromUserID string, toUserID string, limit int) error {
+	origins := make(map[string]bool, len(m.urlsUsers[toUserID]))
+	for _, url := range m.urlsUsers[toUserID] {
+		origins[url.Origin] = true
+	}
+
+	now := time.Now()
+	n := 0
+	for _, url := range m.urlsUsers[fromUserID] {
+		if !origins[url.Origin] && !m.deleted[url.Short] && !m.expired[url.Short] && !url.IsExpired(now) {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return checkQuota(m.countUserURLs(toUserID, now), n, limit)
+}
+
+func (m *URLStorage) mergeUserURLs(fromUserID string, toUserID string) int {
+	origins := make(map[string]bool, len(m.urlsUsers[toUserID]))
+	for _, url := range m.urlsUsers[toUserID] {
+		origins[url.Origin] = true
+	}
+
+	n := 0
+	kept := make([]*model.URL, 0)
+	for _, url := range m.urlsUsers[fromUserID] {
+		if origins[url.Origin] {
+			kept = append(kept, url)
+			continue
+		}
+		m.urlsUsers[toUserID] = append(m.urlsUsers[toUserID], url)
+		m.owners[url.Short] = toUserID
+		n++
+	}
+
+	if len(kept) == 0 {
+		delete(m.urlsUsers, fromUserID)
+	} else {
+		m.urlsUsers[fromUserID] = kept
+	}
+	return n
+}
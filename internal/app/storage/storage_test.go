@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	workers    = 8
+	iterations = 200
+)
+
+// runConcurrent calls Add, GetByID, GetUserURLs and Update from several goroutines, run with -race
+func runConcurrent(t *testing.T, st service.Storage) {
+	ctx := context.Background()
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := "user" + strconv.Itoa(w)
+			for i := 0; i < iterations; i++ {
+				short := userID + "_" + strconv.Itoa(i)
+				err := st.Add(ctx, userID, model.NewURL("https://example.com/"+short, short))
+				assert.NoError(t, err)
+
+				_, _ = st.GetByID(ctx, "user0_"+strconv.Itoa(i))
+				_, _ = st.GetUserURLs(ctx, "user0")
+
+				err = st.Update(ctx, userID, model.NewURL("https://example.org/"+short, short))
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		urls, err := st.GetUserURLs(ctx, "user"+strconv.Itoa(w))
+		require.NoError(t, err)
+		assert.Len(t, urls, iterations)
+	}
+
+	url, err := st.GetByID(ctx, "user1_10")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.org/user1_10", url.Origin)
+}
+
+func TestURLStorageConcurrent(t *testing.T) {
+	runConcurrent(t, NewUrls())
+}
+
+func TestFileStorageConcurrent(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+
+	st, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+	runConcurrent(t, st)
+	require.NoError(t, st.Close())
+
+	restored, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	for w := 0; w < workers; w++ {
+		urls, err := restored.GetUserURLs(context.Background(), "user"+strconv.Itoa(w))
+		require.NoError(t, err)
+		assert.Len(t, urls, iterations)
+	}
+
+	url, err := restored.GetByID(context.Background(), "user1_10")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.org/user1_10", url.Origin)
+}
+
+func TestURLStorageUpdateAnotherUser(t *testing.T) {
+	ctx := context.Background()
+	st := NewUrls()
+
+	require.NoError(t, st.Add(ctx, "owner", model.NewURL("https://example.com", "qwertyT")))
+
+	err := st.Update(ctx, "stranger", model.NewURL("https://example.org", "qwertyT"))
+	assert.ErrorIs(t, err, model.NotFoundError{ShortenURL: "qwertyT"})
+
+	url, err := st.GetByID(ctx, "qwertyT")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", url.Origin)
+}
+
+func TestStorageReserveKeys(t *testing.T) {
+	ctx := context.Background()
+	fileStorage, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	storages := map[string]service.Database{
+		"memory": NewUrls(),
+		"file":   fileStorage,
+	}
+
+	for name, db := range storages {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, db.Add(ctx, "owner", model.NewURL("https://example.com", "qwertyT")))
+
+			reserved, err := db.ReserveKeys(ctx, []string{"qwertyT", "aaaaaaa", "bbbbbbb"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"aaaaaaa", "bbbbbbb"}, reserved)
+
+			reserved, err = db.ReserveKeys(ctx, []string{"aaaaaaa", "ccccccc"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"ccccccc"}, reserved)
+
+			err = db.Add(ctx, "another", model.NewURL("https://example.org", "qwertyT"))
+			assert.ErrorAs(t, err, &model.AliasConflictError{})
+
+			require.NoError(t, db.ReleaseKeys(ctx, []string{"ccccccc"}))
+			reserved, err = db.ReserveKeys(ctx, []string{"ccccccc"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"ccccccc"}, reserved, "the released key is reserved again")
+		})
+	}
+}
+
+func TestStorageUsedKeyUnreserved(t *testing.T) {
+	ctx := context.Background()
+	st := NewUrls()
+
+	reserved, err := st.ReserveKeys(ctx, []string{"aaaaaaa"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"aaaaaaa"}, reserved)
+
+	require.NoError(t, st.Add(ctx, "owner", model.NewURL("https://example.com", "aaaaaaa")))
+	assert.Empty(t, st.reserved, "the reservation is dropped when the key is used")
+}
+
+func TestStorageQuotaConcurrent(t *testing.T) {
+	const limit = 5
+	ctx := context.Background()
+	fileStorage, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	storages := map[string]service.Database{
+		"memory": NewUrls(),
+		"file":   fileStorage,
+	}
+
+	for name, db := range storages {
+		t.Run(name, func(t *testing.T) {
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				added    int
+				exceeded int
+			)
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < limit; j++ {
+						short := "w" + strconv.Itoa(i) + "_" + strconv.Itoa(j)
+						err := db.AddWithQuota(ctx, "owner", model.NewURL("https://example.com/"+short, short), limit)
+
+						mu.Lock()
+						if err == nil {
+							added++
+						} else if assert.ErrorAs(t, err, &model.QuotaExceededError{}) {
+							exceeded++
+						}
+						mu.Unlock()
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			assert.Equal(t, limit, added)
+			assert.Equal(t, workers*limit-limit, exceeded)
+
+			err := db.WriteBatchWithQuota(ctx, "owner", map[string]*model.URL{"batch": model.NewURL("https://example.org", "batch")}, limit)
+			assert.ErrorAs(t, err, &model.QuotaExceededError{})
+			n, err := db.CountUserURLs(ctx, "owner", time.Now())
+			require.NoError(t, err)
+			assert.Equal(t, limit, n)
+		})
+	}
+}
+
+// fillDatabase writes links, deletions and clicks that checkDatabase expects to find
+func fillDatabase(t *testing.T, db service.Database) {
+	ctx := context.Background()
+
+	urls := map[string]*model.URL{
+		"aaaaaaa": model.NewURL("https://example.com/a", "aaaaaaa"),
+		"bbbbbbb": model.NewURL("https://example.com/b", "bbbbbbb"),
+	}
+	require.NoError(t, db.WriteBatch(ctx, "owner", urls))
+	require.NoError(t, db.Add(ctx, "another", model.NewURL("https://example.com/c", "ccccccc")))
+
+	err := db.WriteBatch(ctx, "owner", map[string]*model.URL{"ccccccc": model.NewURL("https://example.com/d", "ccccccc")})
+	assert.ErrorIs(t, err, model.AliasConflictError{Alias: "ccccccc"})
+
+	require.NoError(t, db.DeleteBatch(ctx, []model.DeleteUserURLs{
+		{UserID: "owner", Short: "aaaaaaa"},
+		{UserID: "owner", Short: "ccccccc"},
+	}))
+
+	day := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, db.WriteClicks(ctx, []model.Click{
+		{Short: "bbbbbbb", Time: day, IP: "10.0.0.1"},
+		{Short: "bbbbbbb", Time: day, IP: "10.0.0.2"},
+		{Short: "bbbbbbb", Time: day.Add(24 * time.Hour), IP: "10.0.0.1"},
+	}))
+
+	require.NoError(t, db.AddAPIKey(ctx, &model.APIKey{ID: "key1", UserID: "owner", Name: "ci", Hash: "hash1",
+		Scopes: []string{model.ScopeShorten}, CreatedAt: day}))
+	require.NoError(t, db.AddAPIKey(ctx, &model.APIKey{ID: "key2", UserID: "owner", Hash: "hash2",
+		Scopes: []string{model.ScopeRead, model.ScopeStats}, CreatedAt: day.Add(time.Hour)}))
+	require.NoError(t, db.RevokeAPIKey(ctx, "owner", "key2", day.Add(2*time.Hour)))
+
+	err = db.RevokeAPIKey(ctx, "another", "key1", day)
+	assert.ErrorAs(t, err, &model.APIKeyNotFoundError{})
+}
+
+func checkDatabase(t *testing.T, db service.Database) {
+	ctx := context.Background()
+
+	_, err := db.GetByID(ctx, "aaaaaaa")
+	assert.ErrorAs(t, err, &model.GoneError{})
+
+	url, err := db.GetByID(ctx, "ccccccc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/c", url.Origin)
+
+	nURLs, err := db.GetNumberOfURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, nURLs)
+
+	nUsers, err := db.GetNumberOfUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, nUsers)
+
+	nUserURLs, err := db.CountUserURLs(ctx, "owner", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, nUserURLs, "deleted links are not counted")
+
+	stats, err := db.GetClickStats(ctx, "owner", "bbbbbbb")
+	require.NoError(t, err)
+	assert.Equal(t, &model.ClickStats{
+		TotalClicks:    3,
+		UniqueVisitors: 2,
+		Days:           []model.DayClicks{{Date: "2022-10-01", Clicks: 2}, {Date: "2022-10-02", Clicks: 1}},
+	}, stats)
+
+	_, err = db.GetClickStats(ctx, "another", "bbbbbbb")
+	assert.ErrorAs(t, err, &model.NotFoundError{})
+
+	day := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	key, err := db.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, &model.APIKey{ID: "key1", UserID: "owner", Name: "ci", Hash: "hash1",
+		Scopes: []string{model.ScopeShorten}, CreatedAt: day}, key)
+
+	_, err = db.GetAPIKeyByHash(ctx, "unknown")
+	assert.ErrorAs(t, err, &model.InvalidAPIKeyError{})
+
+	keys, err := db.GetAPIKeys(ctx, "owner")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "key1", keys[0].ID)
+	assert.False(t, keys[0].IsRevoked())
+	assert.Equal(t, "key2", keys[1].ID)
+	require.True(t, keys[1].IsRevoked())
+	assert.True(t, day.Add(2*time.Hour).Equal(*keys[1].RevokedAt))
+
+	keys, err = db.GetAPIKeys(ctx, "another")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestURLStorageDatabase(t *testing.T) {
+	db := NewUrls()
+	fillDatabase(t, db)
+	checkDatabase(t, db)
+}
+
+func TestFileStorageDatabase(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+
+	db, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+	fillDatabase(t, db)
+	checkDatabase(t, db)
+	require.NoError(t, db.Close())
+
+	restored, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+	defer restored.Close()
+	checkDatabase(t, restored)
+}
+
+func TestURLStorageMarkExpired(t *testing.T) {
+	ctx := context.Background()
+	db := NewUrls()
+
+	expiresAt := time.Now().Add(time.Hour)
+	url := model.NewURL("https://example.com", "qwertyT")
+	url.ExpiresAt = &expiresAt
+	require.NoError(t, db.Add(ctx, "owner", url))
+
+	n, err := db.MarkExpired(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = db.MarkExpired(ctx, expiresAt)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = db.GetByID(ctx, "qwertyT")
+	assert.ErrorAs(t, err, &model.ExpiredError{})
+}
+
+func TestFileStorageAccounts(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.db")
+
+	db, err := NewFileStorage(fileName)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Add(ctx, "account", model.NewURL("https://example.com/1", "aaaaaaa")))
+	require.NoError(t, db.Add(ctx, "anonymous", model.NewURL("https://example.com/1", "bbbbbbb")))
+	require.NoError(t, db.Add(ctx, "anonymous", model.NewURL("https://example.com/2", "ccccccc")))
+
+	user := &model.User{ID: "account", Username: "alice", PasswordHash: "hash"}
+	require.NoError(t, db.CreateUser(ctx, user))
+	err = db.CreateUser(ctx, &model.User{ID: "another", Username: "alice", PasswordHash: "hash"})
+	assert.ErrorAs(t, err, &model.UsernameTakenError{})
+
+	n, err := db.MergeUserURLs(ctx, "anonymous", "account")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, db.Close())
+
+	// the second pass checks the state restored from the compacted log
+	for pass := 0; pass < 2; pass++ {
+		restored, err := NewFileStorage(fileName)
+		require.NoError(t, err)
+
+		got, err := restored.GetUser(ctx, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, user, got)
+
+		registered, err := restored.IsRegisteredUser(ctx, "account")
+		require.NoError(t, err)
+		assert.True(t, registered)
+
+		registered, err = restored.IsRegisteredUser(ctx, "anonymous")
+		require.NoError(t, err)
+		assert.False(t, registered)
+
+		urls, err := restored.GetUserURLs(ctx, "account")
+		require.NoError(t, err)
+		assert.Len(t, urls, 2)
+
+		urls, err = restored.GetUserURLs(ctx, "anonymous")
+		require.NoError(t, err)
+		require.Len(t, urls, 1)
+		assert.Equal(t, "bbbbbbb", urls[0].Short)
+
+		if pass == 0 {
+			require.NoError(t, restored.Compact())
+		}
+		require.NoError(t, restored.Close())
+	}
+}
+
+func TestStorageQuotaConflict(t *testing.T) {
+	ctx := context.Background()
+	fileStorage, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	storages := map[string]service.Database{
+		"memory": NewUrls(),
+		"file":   fileStorage,
+	}
+
+	for name, db := range storages {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, db.AddWithQuota(ctx, "user1", model.NewURL("https://example.com", "aaaaaaa"), 1))
+
+			err := db.AddWithQuota(ctx, "user1", model.NewURL("https://example.com", "bbbbbbb"), 1)
+			assert.Equal(t, model.ConflictURLError{ShortenURL: "aaaaaaa"}, err, "the existing link is reported at the limit")
+
+			err = db.AddWithQuota(ctx, "user1", model.NewURL("https://example.org", "ccccccc"), 1)
+			assert.ErrorAs(t, err, &model.QuotaExceededError{})
+		})
+	}
+}
+
+func TestStorageMergeQuota(t *testing.T) {
+	ctx := context.Background()
+	fileStorage, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	storages := map[string]service.Database{
+		"memory": NewUrls(),
+		"file":   fileStorage,
+	}
+
+	for name, db := range storages {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, db.Add(ctx, "account", model.NewURL("https://example.com/1", "aaaaaaa")))
+			require.NoError(t, db.Add(ctx, "anonymous", model.NewURL("https://example.com/1", "bbbbbbb")))
+			require.NoError(t, db.Add(ctx, "anonymous", model.NewURL("https://example.com/2", "ccccccc")))
+			require.NoError(t, db.Add(ctx, "anonymous", model.NewURL("https://example.com/3", "ddddddd")))
+			require.NoError(t, db.DeleteBatch(ctx, []model.DeleteUserURLs{{UserID: "anonymous", Short: "ddddddd"}}))
+
+			n, err := db.MergeUserURLsWithQuota(ctx, "anonymous", "account", 1)
+			assert.ErrorAs(t, err, &model.QuotaExceededError{})
+			assert.Equal(t, 0, n)
+
+			urls, err := db.GetUserURLs(ctx, "anonymous")
+			require.NoError(t, err)
+			assert.Len(t, urls, 3, "nothing is moved over the quota")
+
+			n, err = db.MergeUserURLsWithQuota(ctx, "anonymous", "account", 2)
+			require.NoError(t, err, "the deleted link does not count")
+			assert.Equal(t, 2, n)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"context"
+	"time"
 
 	"github.com/kotche/url-shortening-service/internal/app/model"
 )
@@ -23,6 +24,10 @@ func (f *FakeRepo) GetUserURLs(ctx context.Context, userID string) ([]*model.URL
 	return nil, nil
 }
 
+func (f *FakeRepo) Update(ctx context.Context, userID string, url *model.URL) error {
+	return nil
+}
+
 func (f *FakeRepo) Close() error {
 	return nil
 }
@@ -46,3 +51,79 @@ func (f *FakeRepo) GetNumberOfURLs(ctx context.Context) (int, error) {
 func (f *FakeRepo) GetNumberOfUsers(ctx context.Context) (int, error) {
 	return 0, nil
 }
+
+func (f *FakeRepo) MarkExpired(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
+
+func (f *FakeRepo) WriteClicks(ctx context.Context, clicks []model.Click) error {
+	return nil
+}
+
+func (f *FakeRepo) GetClickStats(ctx context.Context, userID string, shortURL string) (*model.ClickStats, error) {
+	return &model.ClickStats{}, nil
+}
+
+func (f *FakeRepo) AddAPIKey(ctx context.Context, key *model.APIKey) error {
+	return nil
+}
+
+func (f *FakeRepo) GetAPIKeys(ctx context.Context, userID string) ([]*model.APIKey, error) {
+	return nil, nil
+}
+
+func (f *FakeRepo) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	return nil, model.InvalidAPIKeyError{}
+}
+
+func (f *FakeRepo) RevokeAPIKey(ctx context.Context, userID string, id string, revokedAt time.Time) error {
+	return nil
+}
+
+func (f *FakeRepo) CreateUser(ctx context.Context, user *model.User) error {
+	return nil
+}
+
+func (f *FakeRepo) GetUser(ctx context.Context, username string) (*model.User, error) {
+	return nil, model.InvalidCredentialsError{}
+}
+
+func (f *FakeRepo) IsRegisteredUser(ctx context.Context, userID string) (bool, error) {
+	return false, nil
+}
+
+func (f *FakeRepo) MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	return 0, nil
+}
+
+func (f *FakeRepo) MergeUserURLsWithQuota(ctx context.Context, fromUserID string, toUserID string, limit int) (int, error) {
+	return 0, nil
+}
+
+func (f *FakeRepo) CountUserURLs(ctx context.Context, userID string, now time.Time) (int, error) {
+	return 0, nil
+}
+
+func (f *FakeRepo) ReserveKeys(ctx context.Context, keys []string) ([]string, error) {
+	return keys, nil
+}
+
+func (f *FakeRepo) ReleaseKeys(ctx context.Context, keys []string) error {
+	return nil
+}
+
+func (f *FakeRepo) NextSequence(ctx context.Context, n int) ([]uint64, error) {
+	values := make([]uint64, n)
+	for i := range values {
+		values[i] = uint64(i + 1)
+	}
+	return values, nil
+}
+
+func (f *FakeRepo) AddWithQuota(ctx context.Context, userID string, url *model.URL, limit int) error {
+	return nil
+}
+
+func (f *FakeRepo) WriteBatchWithQuota(ctx context.Context, userID string, urls map[string]*model.URL, limit int) error {
+	return nil
+}
@@ -3,13 +3,19 @@ package grpc
 import (
 	"context"
 	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
 	"github.com/kotche/url-shortening-service/internal/app/model"
 	"github.com/kotche/url-shortening-service/internal/app/service"
+	"github.com/kotche/url-shortening-service/internal/app/transport/grpc/interceptors"
 	pb "github.com/kotche/url-shortening-service/internal/app/transport/grpc/proto"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -23,6 +29,8 @@ type Handler struct {
 	pb.UnimplementedShortenerServer
 	Conf *config.Config
 	Cm   ICookieManager
+
+	trustedProxies utils.Subnets
 }
 
 func NewHandler(service *service.Service, conf *config.Config) *Handler {
@@ -31,6 +39,11 @@ func NewHandler(service *service.Service, conf *config.Config) *Handler {
 		Conf:    conf,
 		Cm:      model.CookieManagerMD{},
 	}
+	trustedProxies, err := utils.ParseSubnets(conf.TrustedProxies)
+	if err != nil {
+		log.Printf("grpc NewHandler: trusted proxies: %s", err)
+	}
+	handler.trustedProxies = trustedProxies
 	return handler
 }
 
@@ -51,11 +64,24 @@ func (h *Handler) HandlePost(ctx context.Context, r *pb.HandlePostRequest) (*pb.
 	}
 
 	originURL := r.OriginURL
-	urlModel, err := h.Service.GetURLModel(ctx, userID, originURL)
+	opts := model.ShortenOptions{
+		Alias:     r.Alias,
+		ExpiresAt: unixToTime(r.ExpiresAt),
+		TTL:       time.Duration(r.TtlSeconds) * time.Second,
+	}
+	urlModel, err := h.Service.GetURLModelWithOptions(ctx, userID, originURL, opts)
 
 	if errors.As(err, &model.ConflictURLError{}) {
 		e := err.(model.ConflictURLError)
 		return nil, status.Errorf(codes.AlreadyExists, "handlePost error: %s", e.Error())
+	} else if errors.As(err, &model.AliasConflictError{}) {
+		return nil, status.Errorf(codes.AlreadyExists, "handlePost error: %s", err.Error())
+	} else if errors.As(err, &model.ValidationError{}) {
+		return nil, status.Errorf(codes.InvalidArgument, "handlePost error: %s", err.Error())
+	} else if errors.As(err, &model.QuotaExceededError{}) {
+		return nil, status.Errorf(codes.ResourceExhausted, "handlePost error: %s", err.Error())
+	} else if errors.As(err, &model.BlockedDomainError{}) {
+		return nil, status.Errorf(codes.PermissionDenied, "handlePost error: %s", err.Error())
 	} else if err != nil {
 		return nil, status.Errorf(codes.Internal, "handlePost error: %s", err.Error())
 	}
@@ -68,17 +94,24 @@ func (h *Handler) HandlePost(ctx context.Context, r *pb.HandlePostRequest) (*pb.
 	return &response, nil
 }
 
-// HandleGet gets the original URL from a shortened link
+// HandleGet gets the original URL from a shortened link. A missing link is NotFound, a link that no longer
+// works, i.e. deleted or expired, is FailedPrecondition like 410 Gone of REST
 func (h *Handler) HandleGet(ctx context.Context, r *pb.HandleGetRequest) (*pb.HandleGetResponse, error) {
 	shortURL := r.ShortURL
 	url, err := h.Service.GetURLModelByID(ctx, shortURL)
 
-	if errors.As(err, &model.GoneError{}) {
+	if errors.As(err, &model.NotFoundError{}) {
 		return nil, status.Errorf(codes.NotFound, "handleGet error: %s", err.Error())
+	} else if errors.As(err, &model.GoneError{}) || errors.As(err, &model.ExpiredError{}) {
+		return nil, status.Errorf(codes.FailedPrecondition, "handleGet error: %s", err.Error())
+	} else if errors.As(err, &model.BlockedDomainError{}) {
+		return nil, status.Errorf(codes.PermissionDenied, "handleGet error: %s", err.Error())
 	} else if err != nil {
 		return nil, status.Errorf(codes.Internal, "handleGet error: %s", err.Error())
 	}
 
+	h.Service.RecordClick(h.makeClick(ctx, shortURL))
+
 	response := pb.HandleGetResponse{
 		Status:    int32(http.StatusTemporaryRedirect),
 		OriginURL: url.Origin,
@@ -132,11 +165,22 @@ func (h *Handler) HandlePostShortenBatch(ctx context.Context, r *pb.HandlePostSh
 		inputDataList = append(inputDataList, model.InputCorrelationURL{
 			CorrelationID: corURLInput.Id,
 			Origin:        corURLInput.OriginalURL,
+			Alias:         corURLInput.Alias,
+			ExpiresAt:     unixToTime(corURLInput.ExpiresAt),
+			TTLSeconds:    corURLInput.TtlSeconds,
 		})
 	}
 
 	outputDataList, err := h.Service.ShortenBatch(ctx, userID, inputDataList)
-	if err != nil {
+	if errors.As(err, &model.AliasConflictError{}) {
+		return nil, status.Errorf(codes.AlreadyExists, "HandlePostShortenBatch error: %s", err.Error())
+	} else if errors.As(err, &model.ValidationError{}) {
+		return nil, status.Errorf(codes.InvalidArgument, "HandlePostShortenBatch error: %s", err.Error())
+	} else if errors.As(err, &model.QuotaExceededError{}) {
+		return nil, status.Errorf(codes.ResourceExhausted, "HandlePostShortenBatch error: %s", err.Error())
+	} else if errors.As(err, &model.BlockedDomainError{}) {
+		return nil, status.Errorf(codes.PermissionDenied, "HandlePostShortenBatch error: %s", err.Error())
+	} else if err != nil {
 		return nil, status.Errorf(codes.Internal, "HandlePostShortenBatch error: %s", err.Error())
 	}
 
@@ -178,5 +222,267 @@ func (h *Handler) HandleGetStats(ctx context.Context, r *pb.HandleGetStatsReques
 	}
 
 	response := pb.HandleGetStatsResponse{Urls: int64(stats.NumberOfURLs), Users: int64(stats.NumberOfUsers)}
+	if stats.Cache != nil {
+		response.CacheHits = int64(stats.Cache.Hits)
+		response.CacheMisses = int64(stats.Cache.Misses)
+	}
+	return &response, nil
+}
+
+// HandleGetURLStats returns the click statistics of the shortened link to its owner
+func (h *Handler) HandleGetURLStats(ctx context.Context, r *pb.HandleGetURLStatsRequest) (*pb.HandleGetURLStatsResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "HandleGetURLStats error: %s", "user ID is empty")
+	}
+
+	stats, err := h.Service.GetClickStats(ctx, userID, r.ShortURL)
+	if errors.As(err, &model.NotFoundError{}) {
+		return nil, status.Errorf(codes.NotFound, "HandleGetURLStats error: %s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "HandleGetURLStats error: %s", err.Error())
+	}
+
+	response := pb.HandleGetURLStatsResponse{
+		TotalClicks:    int64(stats.TotalClicks),
+		UniqueVisitors: int64(stats.UniqueVisitors),
+	}
+	for _, day := range stats.Days {
+		response.Days = append(response.Days, &pb.DayClicks{Date: day.Date, Clicks: int64(day.Clicks)})
+	}
+
+	return &response, nil
+}
+
+// GetQuota returns the number of the user's live links and the user's quota, zero limit means no quota
+func (h *Handler) GetQuota(ctx context.Context, r *pb.GetQuotaRequest) (*pb.GetQuotaResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "GetQuota error: %s", "user ID is empty")
+	}
+
+	usage, err := h.Service.GetQuota(ctx, userID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetQuota error: %s", err.Error())
+	}
+
+	response := pb.GetQuotaResponse{
+		Used:  int64(usage.Used),
+		Limit: int64(usage.Limit),
+	}
+	return &response, nil
+}
+
+// UpdateURL changes the original URL of the user's shortened link
+func (h *Handler) UpdateURL(ctx context.Context, r *pb.UpdateURLRequest) (*pb.UpdateURLResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "UpdateURL error: %s", "user ID is empty")
+	}
+
+	urlModel, err := h.Service.UpdateURL(ctx, userID, r.ShortURL, r.OriginURL)
+	if errors.As(err, &model.NotFoundError{}) {
+		return nil, status.Errorf(codes.NotFound, "UpdateURL error: %s", err.Error())
+	} else if errors.As(err, &model.ConflictURLError{}) {
+		return nil, status.Errorf(codes.AlreadyExists, "UpdateURL error: %s", err.Error())
+	} else if errors.As(err, &model.ValidationError{}) {
+		return nil, status.Errorf(codes.InvalidArgument, "UpdateURL error: %s", err.Error())
+	} else if errors.As(err, &model.BlockedDomainError{}) {
+		return nil, status.Errorf(codes.PermissionDenied, "UpdateURL error: %s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "UpdateURL error: %s", err.Error())
+	}
+
+	response := pb.UpdateURLResponse{
+		Status:    int32(http.StatusOK),
+		ShortURL:  h.Conf.BaseURL + "/" + urlModel.Short,
+		OriginURL: urlModel.Origin,
+	}
+	return &response, nil
+}
+
+// CreateAPIKey issues a new API key with the scopes for the user
+func (h *Handler) CreateAPIKey(ctx context.Context, r *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "CreateAPIKey error: %s", "user ID is empty")
+	}
+
+	apiKey, key, err := h.Service.CreateAPIKey(ctx, userID, r.Name, r.Scopes)
+	if errors.As(err, &model.ValidationError{}) {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateAPIKey error: %s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateAPIKey error: %s", err.Error())
+	}
+
+	response := pb.CreateAPIKeyResponse{
+		Status: int32(http.StatusCreated),
+		ApiKey: apiKeyToProto(apiKey),
+		Key:    key,
+	}
+	return &response, nil
+}
+
+// GetAPIKeys returns all API keys of the user including the revoked ones
+func (h *Handler) GetAPIKeys(ctx context.Context, r *pb.GetAPIKeysRequest) (*pb.GetAPIKeysResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "GetAPIKeys error: %s", "user ID is empty")
+	}
+
+	apiKeys, err := h.Service.GetAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetAPIKeys error: %s", err.Error())
+	}
+
+	response := pb.GetAPIKeysResponse{}
+	for _, apiKey := range apiKeys {
+		response.ApiKeys = append(response.ApiKeys, apiKeyToProto(apiKey))
+	}
+	return &response, nil
+}
+
+// RevokeAPIKey revokes the user's API key
+func (h *Handler) RevokeAPIKey(ctx context.Context, r *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "RevokeAPIKey error: %s", "user ID is empty")
+	}
+
+	err := h.Service.RevokeAPIKey(ctx, userID, r.Id)
+	if errors.As(err, &model.APIKeyNotFoundError{}) {
+		return nil, status.Errorf(codes.NotFound, "RevokeAPIKey error: %s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "RevokeAPIKey error: %s", err.Error())
+	}
+
+	response := pb.RevokeAPIKeyResponse{Status: int32(http.StatusNoContent)}
+	return &response, nil
+}
+
+// RefreshToken issues a new token for the current user, so the session is extended without losing the links.
+// The token is also sent in the response header
+func (h *Handler) RefreshToken(ctx context.Context, r *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "RefreshToken error: %s", "user ID is empty")
+	}
+
+	token, err := utils.SignUserID(userID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "RefreshToken error: %s", err.Error())
+	}
+	setSessionToken(ctx, token)
+
+	response := pb.RefreshTokenResponse{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Second * config.CookieMaxAge).Unix(),
+	}
 	return &response, nil
 }
+
+// Register creates the account for the current user
+func (h *Handler) Register(ctx context.Context, r *pb.CredentialsRequest) (*pb.SessionResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Internal, "Register error: %s", "user ID is empty")
+	}
+
+	user, err := h.Service.Register(ctx, userID, r.Username, r.Password)
+	if errors.As(err, &model.UsernameTakenError{}) {
+		return nil, status.Errorf(codes.AlreadyExists, "Register error: %s", err.Error())
+	} else if errors.As(err, &model.ValidationError{}) {
+		return nil, status.Errorf(codes.InvalidArgument, "Register error: %s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "Register error: %s", err.Error())
+	}
+
+	return newSession(ctx, user, http.StatusCreated)
+}
+
+// Login checks the credentials and switches the session to the account,
+// the links of the anonymous session are merged into the account
+func (h *Handler) Login(ctx context.Context, r *pb.CredentialsRequest) (*pb.SessionResponse, error) {
+	userID := h.Cm.GetUserID(ctx)
+
+	user, err := h.Service.Login(ctx, userID, r.Username, r.Password)
+	if errors.As(err, &model.InvalidCredentialsError{}) {
+		return nil, status.Errorf(codes.Unauthenticated, "Login error: %s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "Login error: %s", err.Error())
+	}
+
+	return newSession(ctx, user, http.StatusOK)
+}
+
+// newSession issues the token of the account and sends it in the response header as well
+func newSession(ctx context.Context, user *model.User, statusCode int) (*pb.SessionResponse, error) {
+	token, err := utils.SignUserID(user.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "session error: %s", err.Error())
+	}
+	setSessionToken(ctx, token)
+
+	return &pb.SessionResponse{
+		Status:    int32(statusCode),
+		UserID:    user.ID,
+		Username:  user.Username,
+		Token:     token,
+		ExpiresAt: time.Now().Add(time.Second * config.CookieMaxAge).Unix(),
+	}, nil
+}
+
+// setSessionToken sends the token to the client in the response header instead of the one issued by the interceptor
+func setSessionToken(ctx context.Context, token string) {
+	if interceptors.SetSessionToken(ctx, token) {
+		return
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(string(config.UserIDCookieName), token)); err != nil {
+		log.Printf("setSessionToken error: %s", err)
+	}
+}
+
+// apiKeyToProto converts the API key without the hash
+func apiKeyToProto(apiKey *model.APIKey) *pb.APIKey {
+	key := &pb.APIKey{
+		Id:        apiKey.ID,
+		Name:      apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: apiKey.CreatedAt.Unix(),
+	}
+	if apiKey.RevokedAt != nil {
+		key.RevokedAt = apiKey.RevokedAt.Unix()
+	}
+	return key
+}
+
+// makeClick collects information about the click from the request metadata. The client's address is resolved
+// through the trusted proxies like in the interceptors
+func (h *Handler) makeClick(ctx context.Context, shortURL string) model.Click {
+	click := model.Click{Short: shortURL, Time: time.Now()}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			click.UserAgent = values[0]
+		}
+		if values := md.Get("referer"); len(values) > 0 {
+			click.Referrer = values[0]
+		}
+	}
+
+	if ip := interceptors.ClientIP(ctx, h.trustedProxies); ip != nil {
+		click.IP = ip.String()
+	}
+
+	return click
+}
+
+// unixToTime converts the unix time in seconds to time, zero value means no time
+func unixToTime(sec int64) *time.Time {
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0)
+	return &t
+}
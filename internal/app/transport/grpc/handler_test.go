@@ -0,0 +1,54 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/service"
+	"github.com/kotche/url-shortening-service/internal/app/storage"
+	pb "github.com/kotche/url-shortening-service/internal/app/transport/grpc/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHandlerHandleGet(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewUrls()
+	require.NoError(t, st.Add(ctx, "user", model.NewURL("https://example.com", "live")))
+	require.NoError(t, st.Add(ctx, "user", model.NewURL("https://example.org", "deleted")))
+	require.NoError(t, st.DeleteBatch(ctx, []model.DeleteUserURLs{{UserID: "user", Short: "deleted"}}))
+	expired := model.NewURL("https://example.net", "expired")
+	expiresAt := time.Now().Add(-time.Hour)
+	expired.ExpiresAt = &expiresAt
+	require.NoError(t, st.Add(ctx, "user", expired))
+
+	s := service.NewService(st)
+	s.SetDB(st)
+	h := NewHandler(s, &config.Config{})
+
+	tests := []struct {
+		name     string
+		shortURL string
+		code     codes.Code
+	}{
+		{name: "live", shortURL: "live", code: codes.OK},
+		{name: "missing", shortURL: "missing", code: codes.NotFound},
+		{name: "deleted", shortURL: "deleted", code: codes.FailedPrecondition},
+		{name: "expired", shortURL: "expired", code: codes.FailedPrecondition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := h.HandleGet(ctx, &pb.HandleGetRequest{ShortURL: tt.shortURL})
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				assert.Equal(t, "https://example.com", response.OriginURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const apiKeyKey = "x-api-key"
+
+// APIKeyResolver returns the active API key by its value
+type APIKeyResolver interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*model.APIKey, error)
+}
+
+// APIKeyAuth authenticates the machine clients by the API keys and checks the scopes of the methods
+type APIKeyAuth struct {
+	resolver APIKeyResolver
+	scopes   map[string]string
+}
+
+// NewAPIKeyAuth gets the scopes required by the full method names. Empty scope means any key is allowed,
+// the methods not listed are not available with the API keys
+func NewAPIKeyAuth(resolver APIKeyResolver, scopes map[string]string) *APIKeyAuth {
+	return &APIKeyAuth{resolver: resolver, scopes: scopes}
+}
+
+// UnaryAPIKeyInterceptor authenticates the client by the "x-api-key" metadata as the owner of the key.
+// Calls without the key are passed on to the other authentications
+func (a *APIKeyAuth) UnaryAPIKeyInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return handler(ctx, req)
+	}
+	values := md.Get(apiKeyKey)
+	if len(values) == 0 {
+		return handler(ctx, req)
+	}
+
+	apiKey, err := a.resolver.AuthenticateAPIKey(ctx, values[0])
+	if errors.As(err, &model.InvalidAPIKeyError{}) {
+		return nil, status.Errorf(codes.Unauthenticated, "api key error: %s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "api key error: %s", err.Error())
+	}
+
+	scope, ok := a.scopes[info.FullMethod]
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "api key error: %s is not available with the API key", info.FullMethod)
+	}
+	if scope != "" && !apiKey.HasScope(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "api key error: %s", model.ScopeError{Scope: scope}.Error())
+	}
+
+	ctx = context.WithValue(ctx, config.UserIDCookieName, apiKey.UserID)
+	ctx = context.WithValue(ctx, config.APIKeyContext, apiKey)
+	return handler(ctx, req)
+}
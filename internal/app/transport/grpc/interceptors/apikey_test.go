@@ -0,0 +1,104 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeResolver map[string]*model.APIKey
+
+func (r fakeResolver) AuthenticateAPIKey(_ context.Context, key string) (*model.APIKey, error) {
+	if apiKey, ok := r[key]; ok {
+		return apiKey, nil
+	}
+	return nil, model.InvalidAPIKeyError{}
+}
+
+func TestUnaryAPIKeyInterceptor(t *testing.T) {
+	const (
+		shortenMethod = "/shortener.Shortener/HandlePost"
+		statsMethod   = "/shortener.Shortener/HandleGetURLStats"
+		publicMethod  = "/shortener.Shortener/Ping"
+		keysMethod    = "/shortener.Shortener/CreateAPIKey"
+	)
+
+	resolver := fakeResolver{"sk_good": {ID: "key1", UserID: "user1", Scopes: []string{model.ScopeShorten}}}
+	auth := NewAPIKeyAuth(resolver, map[string]string{
+		shortenMethod: model.ScopeShorten,
+		statsMethod:   model.ScopeStats,
+		publicMethod:  "",
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		md     metadata.MD
+		userID string
+		code   codes.Code
+	}{
+		{
+			name:   "scope_granted",
+			method: shortenMethod,
+			md:     metadata.Pairs("x-api-key", "sk_good"),
+			userID: "user1",
+			code:   codes.OK,
+		},
+		{
+			name:   "scope_not_granted",
+			method: statsMethod,
+			md:     metadata.Pairs("x-api-key", "sk_good"),
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "public_method",
+			method: publicMethod,
+			md:     metadata.Pairs("x-api-key", "sk_good"),
+			userID: "user1",
+			code:   codes.OK,
+		},
+		{
+			name:   "method_not_listed",
+			method: keysMethod,
+			md:     metadata.Pairs("x-api-key", "sk_good"),
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "invalid_key",
+			method: shortenMethod,
+			md:     metadata.Pairs("x-api-key", "sk_bad"),
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "without_key",
+			method: keysMethod,
+			code:   codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			var userID string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				userID, _ = ctx.Value(config.UserIDCookieName).(string)
+				return "ok", nil
+			}
+
+			_, err := auth.UnaryAPIKeyInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.userID, userID)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const authorizationKey = "authorization"
+
+// UnaryBearerInterceptor authenticates the user by the "authorization: Bearer <token>" metadata.
+// Calls without the token are passed on to the cookie authentication, invalid tokens are rejected.
+// Calls already authenticated by the API key are passed as is
+func UnaryBearerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if _, ok := ctx.Value(config.UserIDCookieName).(string); ok {
+		return handler(ctx, req)
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return handler(ctx, req)
+	}
+	values := md.Get(authorizationKey)
+	if len(values) == 0 {
+		return handler(ctx, req)
+	}
+
+	userID, err := utils.GetUserIDFromBearer(values[0])
+	if errors.Is(err, utils.ErrNoBearer) {
+		return handler(ctx, req)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "bearer token error: %s", err.Error())
+	}
+
+	return handler(context.WithValue(ctx, config.UserIDCookieName, userID), req)
+}
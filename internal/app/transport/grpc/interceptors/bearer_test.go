@@ -0,0 +1,76 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeAuthenticator map[string]string
+
+func (a fakeAuthenticator) Authenticate(token string) (string, error) {
+	if userID, ok := a[token]; ok {
+		return userID, nil
+	}
+	return "", fmt.Errorf("invalid token")
+}
+
+func TestUnaryBearerInterceptor(t *testing.T) {
+	utils.SetAuthenticator(fakeAuthenticator{"good": "user1"})
+	t.Cleanup(func() { utils.SetAuthenticator(nil) })
+
+	tests := []struct {
+		name   string
+		md     metadata.MD
+		userID string
+		code   codes.Code
+	}{
+		{
+			name:   "valid_token",
+			md:     metadata.Pairs("authorization", "Bearer good"),
+			userID: "user1",
+			code:   codes.OK,
+		},
+		{
+			name: "invalid_token",
+			md:   metadata.Pairs("authorization", "Bearer bad"),
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "other_scheme",
+			md:   metadata.Pairs("authorization", "Basic dXNlcjpwYXNz"),
+			code: codes.OK,
+		},
+		{
+			name: "without_token",
+			code: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			var userID string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				userID, _ = ctx.Value(config.UserIDCookieName).(string)
+				return "ok", nil
+			}
+
+			_, err := UnaryBearerInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.userID, userID)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package interceptors
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const accessProhibited = "Access to the internal network is prohibited"
+
+// TrustedNetwork restricts the internal methods to the clients from the trusted subnets
+type TrustedNetwork struct {
+	TrustedSubnets utils.Subnets
+	TrustedProxies utils.Subnets
+	methods        map[string]struct{}
+}
+
+// NewTrustedNetwork gets the full names of the protected methods. Invalid settings are logged and deny all access
+func NewTrustedNetwork(cfg *config.Config, methods ...string) *TrustedNetwork {
+	trustedSubnets, err := utils.ParseSubnets(cfg.TrustedSubnet)
+	if err != nil {
+		log.Printf("interceptors NewTrustedNetwork: trusted subnet: %s", err)
+	}
+	trustedProxies, err := utils.ParseSubnets(cfg.TrustedProxies)
+	if err != nil {
+		log.Printf("interceptors NewTrustedNetwork: trusted proxies: %s", err)
+	}
+
+	t := &TrustedNetwork{
+		TrustedSubnets: trustedSubnets,
+		TrustedProxies: trustedProxies,
+		methods:        make(map[string]struct{}, len(methods)),
+	}
+	for _, method := range methods {
+		t.methods[method] = struct{}{}
+	}
+	return t
+}
+
+// UnaryTrustedNetworkInterceptor checks whether the client's IP address is included in the trusted subnets
+func (t *TrustedNetwork) UnaryTrustedNetworkInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if _, ok := t.methods[info.FullMethod]; !ok {
+		return handler(ctx, req)
+	}
+
+	if len(t.TrustedSubnets) == 0 {
+		log.Print("interceptors UnaryTrustedNetworkInterceptor: empty TrustedSubnet")
+		return nil, status.Error(codes.PermissionDenied, accessProhibited)
+	}
+
+	ip := ClientIP(ctx, t.TrustedProxies)
+	if !t.TrustedSubnets.Contains(ip) {
+		log.Printf("interceptors UnaryTrustedNetworkInterceptor: TrustedSubnet - %s, ip - %s", t.TrustedSubnets, ip)
+		return nil, status.Error(codes.PermissionDenied, accessProhibited)
+	}
+	return handler(ctx, req)
+}
+
+// ClientIP resolves the client's address from the peer and the headers of the trusted proxies
+func ClientIP(ctx context.Context, trustedProxies utils.Subnets) net.IP {
+	var remoteAddr, realIP, forwardedFor string
+
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-real-ip"); len(values) > 0 {
+			realIP = values[0]
+		}
+		if values := md.Get("x-forwarded-for"); len(values) > 0 {
+			forwardedFor = values[0]
+		}
+	}
+
+	return utils.ResolveClientIP(remoteAddr, realIP, forwardedFor, trustedProxies)
+}
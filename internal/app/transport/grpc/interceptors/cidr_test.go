@@ -0,0 +1,132 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryTrustedNetworkInterceptor(t *testing.T) {
+	const internalMethod = "/shortener.Shortener/HandleGetStats"
+
+	tests := []struct {
+		name       string
+		method     string
+		remoteAddr string
+		md         metadata.MD
+		code       codes.Code
+	}{
+		{
+			name:       "access_allowed",
+			method:     internalMethod,
+			remoteAddr: "192.168.1.10:5000",
+			code:       codes.OK,
+		},
+		{
+			name:       "access_denied",
+			method:     internalMethod,
+			remoteAddr: "203.0.113.5:5000",
+			code:       codes.PermissionDenied,
+		},
+		{
+			name:       "access_allowed_through_proxy",
+			method:     internalMethod,
+			remoteAddr: "10.0.0.1:5000",
+			md:         metadata.Pairs("x-real-ip", "192.168.1.10"),
+			code:       codes.OK,
+		},
+		{
+			name:       "access_denied_forged_header",
+			method:     internalMethod,
+			remoteAddr: "203.0.113.5:5000",
+			md:         metadata.Pairs("x-real-ip", "192.168.1.10"),
+			code:       codes.PermissionDenied,
+		},
+		{
+			name:       "public_method",
+			method:     "/shortener.Shortener/HandleGet",
+			remoteAddr: "203.0.113.5:5000",
+			code:       codes.OK,
+		},
+	}
+
+	conf := &config.Config{TrustedSubnet: "192.168.1.0/24", TrustedProxies: "10.0.0.1"}
+	trustedNetwork := NewTrustedNetwork(conf, internalMethod)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := net.ResolveTCPAddr("tcp", tt.remoteAddr)
+			assert.NoError(t, err)
+
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			_, err = trustedNetwork.UnaryTrustedNetworkInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		md         metadata.MD
+		want       string
+	}{
+		{
+			name:       "direct_client",
+			remoteAddr: "192.168.1.10:5000",
+			want:       "192.168.1.10",
+		},
+		{
+			name:       "untrusted_peer_headers_ignored",
+			remoteAddr: "192.168.1.10:5000",
+			md:         metadata.Pairs("x-real-ip", "8.8.8.8"),
+			want:       "192.168.1.10",
+		},
+		{
+			name:       "trusted_proxy_real_ip",
+			remoteAddr: "10.0.0.1:5000",
+			md:         metadata.Pairs("x-real-ip", "8.8.8.8"),
+			want:       "8.8.8.8",
+		},
+		{
+			name:       "trusted_proxy_forwarded_for",
+			remoteAddr: "10.0.0.1:5000",
+			md:         metadata.Pairs("x-forwarded-for", "8.8.4.4, 10.0.0.1"),
+			want:       "8.8.4.4",
+		},
+	}
+
+	conf := &config.Config{TrustedProxies: "10.0.0.1"}
+	trustedProxies := NewTrustedNetwork(conf).TrustedProxies
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := net.ResolveTCPAddr("tcp", tt.remoteAddr)
+			assert.NoError(t, err)
+
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			assert.Equal(t, tt.want, ClientIP(ctx, trustedProxies).String())
+		})
+	}
+}
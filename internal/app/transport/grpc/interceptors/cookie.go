@@ -2,21 +2,103 @@ package interceptors
 
 import (
 	"context"
+	"log"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
 	"github.com/kotche/url-shortening-service/internal/app/utils"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+type sessionKey struct{}
+
+// session holds the token sent to the client in the response header
+type session struct {
+	token  string
+	issued bool
+}
+
+// isNewUser reports whether the user ID of the call is just issued, i.e. the client sent no valid cookie
+func isNewUser(ctx context.Context) bool {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	return ok && s.issued
+}
+
+// SetSessionToken replaces the token sent to the client in the response header, e.g. after the login.
+// Returns false if the call is not handled by UnaryCookieInterceptor
+func SetSessionToken(ctx context.Context, token string) bool {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if ok {
+		s.token = token
+	}
+	return ok
+}
+
 // UnaryCookieInterceptor checks for the presence of the user ID in the cookie file. If not, then a new one is issued
+// and sent back to the client in the response header, so the next calls are made by the same user.
+// Calls already authenticated by the bearer token are passed as is
 func UnaryCookieInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	userID := utils.GetUserIDFromMD(ctx)
-	if userID != "" {
-		return handler(ctx, req)
-	}
-	_, userIDCookie := utils.MakeUserIDCookie()
-	md := metadata.New(map[string]string{string(config.UserIDCookieName): userIDCookie})
-	newCtx := metadata.NewIncomingContext(ctx, md)
-	return handler(newCtx, req)
+	newCtx, userIDCookie, err := authenticateCookie(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cookie error: %s", err)
+	}
+
+	s := &session{token: userIDCookie, issued: userIDCookie != ""}
+	resp, err := handler(context.WithValue(newCtx, sessionKey{}, s), req)
+
+	if s.token != "" {
+		if errHeader := grpc.SetHeader(ctx, userIDMD(s.token)); errHeader != nil {
+			log.Printf("interceptors UnaryCookieInterceptor: %s", errHeader)
+		}
+	}
+	return resp, err
+}
+
+// StreamCookieInterceptor is the streaming counterpart of UnaryCookieInterceptor
+func StreamCookieInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	newCtx, userIDCookie, err := authenticateCookie(ss.Context())
+	if err != nil {
+		return status.Errorf(codes.Internal, "cookie error: %s", err)
+	}
+	if userIDCookie != "" {
+		if err := ss.SetHeader(userIDMD(userIDCookie)); err != nil {
+			log.Printf("interceptors StreamCookieInterceptor: %s", err)
+		}
+	}
+	return handler(srv, &serverStream{ServerStream: ss, ctx: newCtx})
+}
+
+// authenticateCookie returns the context with the user ID. If a new user ID is issued, its cookie is returned as well
+func authenticateCookie(ctx context.Context) (context.Context, string, error) {
+	if _, ok := ctx.Value(config.UserIDCookieName).(string); ok {
+		return ctx, "", nil
+	}
+	if userID := utils.GetUserIDFromMD(ctx); userID != "" {
+		return ctx, "", nil
+	}
+
+	_, userIDCookie, err := utils.MakeUserIDCookie()
+	if err != nil {
+		return nil, "", err
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Set(string(config.UserIDCookieName), userIDCookie)
+	return metadata.NewIncomingContext(ctx, md), userIDCookie, nil
+}
+
+func userIDMD(userIDCookie string) metadata.MD {
+	return metadata.Pairs(string(config.UserIDCookieName), userIDCookie)
+}
+
+// serverStream overrides the context of the stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
 }
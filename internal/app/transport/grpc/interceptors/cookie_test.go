@@ -0,0 +1,91 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeTransportStream collects the response header
+type fakeTransportStream struct {
+	header metadata.MD
+}
+
+func (s *fakeTransportStream) Method() string { return "" }
+
+func (s *fakeTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *fakeTransportStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *fakeTransportStream) SetTrailer(metadata.MD) error { return nil }
+
+// fakeServerStream is the server stream with the response header
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestUnaryCookieInterceptor(t *testing.T) {
+	userIDName := string(config.UserIDCookieName)
+
+	var userID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		userID = utils.GetUserIDFromMD(ctx)
+		return "ok", nil
+	}
+
+	stream := &fakeTransportStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("user-agent", "test"))
+
+	_, err := UnaryCookieInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	require.NotEmpty(t, userID)
+	issued := stream.header.Get(userIDName)
+	require.Len(t, issued, 1, "the new token is sent to the client")
+	assert.Equal(t, userID, utils.GetUserIDFromCookie(issued[0]))
+
+	firstUserID := userID
+	stream = &fakeTransportStream{}
+	ctx = grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(userIDName, issued[0]))
+
+	_, err = UnaryCookieInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, firstUserID, userID, "the client stays the same user")
+	assert.Empty(t, stream.header.Get(userIDName))
+}
+
+func TestStreamCookieInterceptor(t *testing.T) {
+	userIDName := string(config.UserIDCookieName)
+
+	var userID string
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		userID = utils.GetUserIDFromMD(ss.Context())
+		return nil
+	}
+
+	ss := &fakeServerStream{ctx: context.Background()}
+	require.NoError(t, StreamCookieInterceptor(nil, ss, &grpc.StreamServerInfo{}, handler))
+	require.NotEmpty(t, userID)
+	issued := ss.header.Get(userIDName)
+	require.Len(t, issued, 1)
+	assert.Equal(t, userID, utils.GetUserIDFromCookie(issued[0]))
+}
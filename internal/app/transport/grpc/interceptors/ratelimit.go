@@ -0,0 +1,59 @@
+package interceptors
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/ratelimit"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimit limits the calls of the users and the client addresses by the limiters of the methods.
+// Methods without a limiter are not limited
+type RateLimit struct {
+	TrustedProxies utils.Subnets
+	limiters       map[string]ratelimit.Limiter
+}
+
+// NewRateLimit gets the limiters by the full names of the methods
+func NewRateLimit(trustedProxies utils.Subnets, limiters map[string]ratelimit.Limiter) *RateLimit {
+	return &RateLimit{
+		TrustedProxies: trustedProxies,
+		limiters:       limiters,
+	}
+}
+
+// UnaryRateLimitInterceptor returns ResourceExhausted with the retry-after header when the limit is exceeded.
+// It must follow the authentication interceptors to get the user ID. The callers without a cookie get a new user ID
+// on every call, so they are limited by the client address only
+func (l *RateLimit) UnaryRateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	limiter, ok := l.limiters[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	var ip string
+	if addr := ClientIP(ctx, l.TrustedProxies); addr != nil {
+		ip = addr.String()
+	}
+	var userID string
+	if !isNewUser(ctx) {
+		userID = model.CookieManagerMD{}.GetUserID(ctx)
+	}
+
+	if ok, wait := ratelimit.AllowAll(limiter, ratelimit.UserKey(userID), ratelimit.IPKey(ip)); !ok {
+		retryAfter := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+		if err := grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter)); err != nil {
+			log.Printf("interceptors UnaryRateLimitInterceptor: %s", err)
+		}
+		return nil, status.Errorf(codes.ResourceExhausted, "RateLimit error: retry after %s seconds", retryAfter)
+	}
+	return handler(ctx, req)
+}
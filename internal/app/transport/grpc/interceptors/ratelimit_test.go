@@ -0,0 +1,141 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/ratelimit"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryRateLimitInterceptor(t *testing.T) {
+	const limitedMethod = "/shortener.Shortener/HandlePost"
+
+	type call struct {
+		method     string
+		userID     string
+		remoteAddr string
+	}
+
+	tests := []struct {
+		name  string
+		calls []call
+		code  codes.Code
+	}{
+		{
+			name: "within_burst",
+			calls: []call{
+				{method: limitedMethod, userID: "user1", remoteAddr: "192.168.1.10:5000"},
+			},
+			code: codes.OK,
+		},
+		{
+			name: "user_limit_exceeded",
+			calls: []call{
+				{method: limitedMethod, userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{method: limitedMethod, userID: "user1", remoteAddr: "192.168.1.11:5000"},
+			},
+			code: codes.ResourceExhausted,
+		},
+		{
+			name: "ip_limit_exceeded",
+			calls: []call{
+				{method: limitedMethod, userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{method: limitedMethod, userID: "user2", remoteAddr: "192.168.1.10:5000"},
+			},
+			code: codes.ResourceExhausted,
+		},
+		{
+			name: "method_without_limit",
+			calls: []call{
+				{method: "/shortener.Shortener/Ping", userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{method: "/shortener.Shortener/Ping", userID: "user1", remoteAddr: "192.168.1.10:5000"},
+			},
+			code: codes.OK,
+		},
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiters := map[string]ratelimit.Limiter{
+				limitedMethod: ratelimit.NewMemoryLimiter(ratelimit.Limit{Rate: 0.001, Burst: 1}),
+			}
+			rateLimit := NewRateLimit(nil, limiters)
+
+			var err error
+			for _, c := range tt.calls {
+				addr, errAddr := net.ResolveTCPAddr("tcp", c.remoteAddr)
+				assert.NoError(t, errAddr)
+
+				ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+				ctx = context.WithValue(ctx, config.UserIDCookieName, c.userID)
+
+				_, err = rateLimit.UnaryRateLimitInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: c.method}, handler)
+			}
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+// keysLimiter records the keys and allows all calls
+type keysLimiter struct {
+	keys []string
+}
+
+func (l *keysLimiter) Allow(keys ...string) (bool, time.Duration) {
+	l.keys = append(l.keys, keys...)
+	return true, 0
+}
+
+func TestUnaryRateLimitInterceptorNewUser(t *testing.T) {
+	const limitedMethod = "/shortener.Shortener/HandlePost"
+	userIDName := string(config.UserIDCookieName)
+
+	limiter := &keysLimiter{}
+	rateLimit := NewRateLimit(nil, map[string]ratelimit.Limiter{limitedMethod: limiter})
+	info := &grpc.UnaryServerInfo{FullMethod: limitedMethod}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return rateLimit.UnaryRateLimitInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "ok", nil
+		})
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", "192.168.1.10:5000")
+	require.NoError(t, err)
+
+	stream := &fakeTransportStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+
+	_, err = UnaryCookieInterceptor(ctx, nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ip:192.168.1.10"}, limiter.keys, "the caller without a cookie is limited by the address")
+
+	issued := stream.header.Get(userIDName)
+	require.Len(t, issued, 1)
+	userID := utils.GetUserIDFromCookie(issued[0])
+
+	limiter.keys = nil
+	ctx = grpc.NewContextWithServerTransportStream(context.Background(), &fakeTransportStream{})
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(userIDName, issued[0]))
+
+	_, err = UnaryCookieInterceptor(ctx, nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:" + userID, "ip:192.168.1.10"}, limiter.keys)
+}
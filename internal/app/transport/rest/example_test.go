@@ -28,7 +28,7 @@ func ExampleHandler_HandlePost() {
 	//Success 201 - URL shortened
 	//Failure 400 - invalid request format
 	//Failure 409 - URL has already been shortened by the current user
-	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("www.yandex.ru"))
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("https://www.yandex.ru"))
 	w := httptest.NewRecorder()
 
 	h.Router.ServeHTTP(w, r)
@@ -50,7 +50,7 @@ func ExampleHandler_HandlePostJSON() {
 	h := NewHandler(s, conf)
 
 	//Input body:
-	//{"url":"www.yandex.ru"}
+	//{"url":"https://www.yandex.ru"}
 	//Return:
 	//{"result":"http://localhost:8080/lBzgbai"}
 	//
@@ -58,7 +58,7 @@ func ExampleHandler_HandlePostJSON() {
 	//Success 201 - URL shortened
 	//Failure 400 - invalid request format
 	//Failure 409 - URL has already been shortened by the current user
-	r := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBufferString(`{"url":"www.yandex.ru"}`))
+	r := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBufferString(`{"url":"https://www.yandex.ru"}`))
 	r.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
@@ -89,6 +89,7 @@ func ExampleHandler_HandleGet() {
 	//Success 307 - redirect to original url
 	//Failure 400 - short URL not found/ internal error
 	//Failure 410 - entry deleted
+	//Failure 451 - domain of the original URL is blocked
 	r := httptest.NewRequest(http.MethodGet, "/qwertyT", nil)
 	w := httptest.NewRecorder()
 
@@ -8,13 +8,16 @@ import (
 	"log"
 	"net/http"
 	"net/http/pprof"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/kotche/url-shortening-service/internal/app/config"
 	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/ratelimit"
 	"github.com/kotche/url-shortening-service/internal/app/service"
 	middlewares2 "github.com/kotche/url-shortening-service/internal/app/transport/rest/middlewares"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
 )
 
 // ICookieManager retrieves the user id from cookies
@@ -23,45 +26,81 @@ type ICookieManager interface {
 }
 
 type Handler struct {
-	Service *service.Service
-	Router  *chi.Mux
-	Conf    *config.Config
-	Cm      ICookieManager
+	Service        *service.Service
+	Router         *chi.Mux
+	Conf           *config.Config
+	Cm             ICookieManager
+	trustedNetwork *middlewares2.TrustedNetwork
+	writeLimit     *middlewares2.RateLimit
+	redirectLimit  *middlewares2.RateLimit
 }
 
 // NewHandler constructor gets a transport instance
 func NewHandler(service *service.Service, conf *config.Config) *Handler {
 	handler := &Handler{
-		Service: service,
-		Conf:    conf,
-		Cm:      model.CookieManager{},
+		Service:        service,
+		Conf:           conf,
+		Cm:             model.CookieManager{},
+		trustedNetwork: middlewares2.NewTrustedNetwork(conf),
 	}
+	handler.writeLimit = handler.newRateLimit(ratelimit.Limit{Rate: conf.WriteRateLimit, Burst: conf.WriteRateBurst})
+	handler.redirectLimit = handler.newRateLimit(ratelimit.Limit{Rate: conf.RedirectRateLimit, Burst: conf.RedirectRateBurst})
 	handler.Router = handler.InitRoutes()
 	return handler
 }
 
+// newRateLimit creates the in-memory limiter keyed by the user ID and the client address
+func (h *Handler) newRateLimit(limit ratelimit.Limit) *middlewares2.RateLimit {
+	return &middlewares2.RateLimit{
+		Limiter:        ratelimit.NewMemoryLimiter(limit),
+		TrustedProxies: h.trustedNetwork.TrustedProxies,
+		UserID: func(r *http.Request) string {
+			return h.Cm.GetUserID(r)
+		},
+	}
+}
+
 // InitRoutes initialization routes
 func (h *Handler) InitRoutes() *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(middleware.Recoverer)
 	router.Use(middlewares2.GzipHandler)
+	router.Use(middlewares2.APIKeyHandler(h.Service))
+	router.Use(middlewares2.BearerHandler)
 	router.Use(middlewares2.UserCookieHandler)
 
-	//main routes
+	//public routes
 	router.Group(func(router chi.Router) {
-		router.Get("/{id}", h.HandleGet)
-		router.Post("/", h.HandlePost)
-		router.Post("/api/shorten", h.HandlePostJSON)
-		router.Get("/api/user/urls", h.HandleGetUserURLs)
+		router.With(h.redirectLimit.RateLimitHandler).Get("/{id}", h.HandleGet)
 		router.Get("/ping", h.HandlePing)
-		router.Post("/api/shorten/batch", h.HandlePostShortenBatch)
-		router.Delete("/api/user/urls", h.HandleDeleteURLs)
+	})
+
+	//main routes, API keys need the scope of the route, writes are rate limited
+	router.Group(func(router chi.Router) {
+		writes := router.With(h.writeLimit.RateLimitHandler)
+		writes.With(middlewares2.RequireScope(model.ScopeShorten)).Post("/", h.HandlePost)
+		writes.With(middlewares2.RequireScope(model.ScopeShorten)).Post("/api/shorten", h.HandlePostJSON)
+		writes.With(middlewares2.RequireScope(model.ScopeShorten)).Post("/api/shorten/batch", h.HandlePostShortenBatch)
+		writes.With(middlewares2.RequireScope(model.ScopeShorten)).Patch("/api/user/urls/{id}", h.HandleUpdateURL)
+		writes.With(middlewares2.RequireScope(model.ScopeDelete)).Delete("/api/user/urls", h.HandleDeleteURLs)
+		router.With(middlewares2.RequireScope(model.ScopeRead)).Get("/api/user/urls", h.HandleGetUserURLs)
+		router.With(middlewares2.RequireScope(model.ScopeRead)).Get("/api/user/quota", h.HandleGetQuota)
+		router.With(middlewares2.RequireScope(model.ScopeStats)).Get("/api/user/urls/{id}/stats", h.HandleGetURLStats)
+	})
+
+	//accounts and API key management, not available with the API keys
+	router.Group(func(router chi.Router) {
+		router.Use(middlewares2.DenyAPIKey)
+		router.With(h.writeLimit.RateLimitHandler).Post("/api/user/register", h.HandleRegister)
+		router.With(h.writeLimit.RateLimitHandler).Post("/api/user/login", h.HandleLogin)
+		router.Post("/api/user/keys", h.HandleCreateAPIKey)
+		router.Get("/api/user/keys", h.HandleGetAPIKeys)
+		router.Delete("/api/user/keys/{keyID}", h.HandleRevokeAPIKey)
 	})
 
 	//trusted network routes
 	router.Group(func(router chi.Router) {
-		trustedNetwork := middlewares2.NewTrustedNetwork(h.Conf)
-		router.Use(trustedNetwork.TrustedNetworkHandler)
+		router.Use(h.trustedNetwork.TrustedNetworkHandler)
 		router.Get("/api/internal/stats", h.HandleGetStats)
 	})
 
@@ -96,6 +135,12 @@ func (h *Handler) HandlePost(w http.ResponseWriter, r *http.Request) {
 		e := err.(model.ConflictURLError)
 		status = http.StatusConflict
 		shortenURL = e.ShortenURL
+	} else if errors.As(err, &model.ValidationError{}) {
+		writeValidationError(w, err)
+		return
+	} else if errors.As(err, &model.QuotaExceededError{}) || errors.As(err, &model.BlockedDomainError{}) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	} else if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -115,7 +160,10 @@ func (h *Handler) HandlePostJSON(w http.ResponseWriter, r *http.Request) {
 	}
 
 	originURLReceiver := &struct {
-		OriginURL string `json:"url"`
+		OriginURL  string     `json:"url"`
+		Alias      string     `json:"alias"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		TTLSeconds int64      `json:"ttl_seconds"`
 	}{}
 
 	var body []byte
@@ -141,13 +189,28 @@ func (h *Handler) HandlePostJSON(w http.ResponseWriter, r *http.Request) {
 	status := http.StatusCreated
 	var shortenURL string
 
+	opts := model.ShortenOptions{
+		Alias:     originURLReceiver.Alias,
+		ExpiresAt: originURLReceiver.ExpiresAt,
+		TTL:       time.Duration(originURLReceiver.TTLSeconds) * time.Second,
+	}
+
 	ctx := context.Background()
-	urlModel, err := h.Service.GetURLModel(ctx, userID, originURL)
+	urlModel, err := h.Service.GetURLModelWithOptions(ctx, userID, originURL, opts)
 
 	if errors.As(err, &model.ConflictURLError{}) {
 		e := err.(model.ConflictURLError)
 		status = http.StatusConflict
 		shortenURL = e.ShortenURL
+	} else if errors.As(err, &model.AliasConflictError{}) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if errors.As(err, &model.ValidationError{}) {
+		writeValidationError(w, err)
+		return
+	} else if errors.As(err, &model.QuotaExceededError{}) || errors.As(err, &model.BlockedDomainError{}) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	} else if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -178,17 +241,39 @@ func (h *Handler) HandleGet(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 	url, err := h.Service.GetURLModelByID(ctx, shortURL)
 
-	if errors.As(err, &model.GoneError{}) {
+	if errors.As(err, &model.GoneError{}) || errors.As(err, &model.ExpiredError{}) {
 		w.WriteHeader(http.StatusGone)
 		return
+	} else if errors.As(err, &model.BlockedDomainError{}) {
+		http.Error(w, err.Error(), http.StatusUnavailableForLegalReasons)
+		return
 	} else if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+
+	h.Service.RecordClick(h.makeClick(r, shortURL))
+
 	w.Header().Set("Location", url.Origin)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
+// makeClick collects the click on the short link from the request, the IP is empty if the client is unknown
+func (h *Handler) makeClick(r *http.Request, shortURL string) model.Click {
+	click := model.Click{
+		Short:     shortURL,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+
+	if ip := utils.GetClientIP(r, h.trustedNetwork.TrustedProxies); ip != nil {
+		click.IP = ip.String()
+	}
+
+	return click
+}
+
 // HandleGetUserURLs gets all shortened links by the user
 func (h *Handler) HandleGetUserURLs(w http.ResponseWriter, r *http.Request) {
 
@@ -236,6 +321,58 @@ func (h *Handler) HandleGetUserURLs(w http.ResponseWriter, r *http.Request) {
 	w.Write(userUrlsJSON)
 }
 
+// HandleGetURLStats returns the click statistics of the shortened link to its owner
+func (h *Handler) HandleGetURLStats(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleGetURLStats"
+	shortURL := chi.URLParam(r, "id")
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	stats, err := h.Service.GetClickStats(ctx, userID, shortURL)
+	if errors.As(err, &model.NotFoundError{}) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(statsJSON)
+}
+
+// HandleGetQuota returns the number of the user's live links and the user's quota, zero limit means no quota
+func (h *Handler) HandleGetQuota(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleGetQuota"
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	usage, err := h.Service.GetQuota(ctx, userID)
+	if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(usageJSON)
+}
+
 // HandlePing checks the availability of the database
 func (h *Handler) HandlePing(w http.ResponseWriter, _ *http.Request) {
 	ctx := context.Background()
@@ -269,7 +406,16 @@ func (h *Handler) HandlePostShortenBatch(w http.ResponseWriter, r *http.Request)
 	ctx := context.Background()
 
 	outputDataList, err := h.Service.ShortenBatch(ctx, userID, inputDataList)
-	if err != nil {
+	if errors.As(err, &model.AliasConflictError{}) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if errors.As(err, &model.ValidationError{}) {
+		writeValidationError(w, err)
+		return
+	} else if errors.As(err, &model.QuotaExceededError{}) || errors.As(err, &model.BlockedDomainError{}) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -307,6 +453,269 @@ func (h *Handler) HandleDeleteURLs(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// HandleUpdateURL changes the original URL of the user's shortened link. Content-Type: application/json
+func (h *Handler) HandleUpdateURL(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleUpdateURL"
+	shortURL := chi.URLParam(r, "id")
+
+	originURLReceiver := &struct {
+		OriginURL string `json:"url"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(originURLReceiver)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	urlModel, err := h.Service.UpdateURL(ctx, userID, shortURL, originURLReceiver.OriginURL)
+	if errors.As(err, &model.NotFoundError{}) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.As(err, &model.ConflictURLError{}) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if errors.As(err, &model.ValidationError{}) {
+		writeValidationError(w, err)
+		return
+	} else if errors.As(err, &model.BlockedDomainError{}) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	output := struct {
+		ShortURL    string `json:"short_url"`
+		OriginalURL string `json:"original_url"`
+	}{
+		ShortURL:    h.Conf.BaseURL + "/" + urlModel.Short,
+		OriginalURL: urlModel.Origin,
+	}
+
+	outputJSON, err := json.Marshal(output)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(outputJSON)
+}
+
+// credentialsInput is the body of the registration and login requests
+type credentialsInput struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HandleRegister creates the account for the current user. Content-Type: application/json
+func (h *Handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleRegister"
+
+	input := &credentialsInput{}
+	err := json.NewDecoder(r.Body).Decode(input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	user, err := h.Service.Register(ctx, userID, input.Username, input.Password)
+	if errors.As(err, &model.UsernameTakenError{}) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if errors.As(err, &model.ValidationError{}) {
+		writeValidationError(w, err)
+		return
+	} else if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeSession(w, user, http.StatusCreated)
+}
+
+// HandleLogin checks the credentials and switches the session to the account, the links of the anonymous
+// session are merged into the account. Content-Type: application/json
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleLogin"
+
+	input := &credentialsInput{}
+	err := json.NewDecoder(r.Body).Decode(input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	user, err := h.Service.Login(ctx, userID, input.Username, input.Password)
+	if errors.As(err, &model.InvalidCredentialsError{}) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeSession(w, user, http.StatusOK)
+}
+
+// writeSession sets the cookie of the account and returns the token for the clients without cookies
+func (h *Handler) writeSession(w http.ResponseWriter, user *model.User, status int) {
+	token, err := utils.SignUserID(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cookie := http.Cookie{Name: string(config.UserIDCookieName), Value: token, Path: "/", MaxAge: config.CookieMaxAge}
+	http.SetCookie(w, &cookie)
+
+	output := struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}{
+		UserID:   user.ID,
+		Username: user.Username,
+		Token:    token,
+	}
+
+	outputJSON, err := json.Marshal(output)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(outputJSON)
+}
+
+// apiKeyOutput is the API key without the hash. The key itself is returned only on creation
+type apiKeyOutput struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key,omitempty"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func newAPIKeyOutput(apiKey *model.APIKey, key string) apiKeyOutput {
+	return apiKeyOutput{
+		ID:        apiKey.ID,
+		Key:       key,
+		Name:      apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: apiKey.CreatedAt,
+		RevokedAt: apiKey.RevokedAt,
+	}
+}
+
+// HandleCreateAPIKey issues a new API key with the scopes for the user. Content-Type: application/json
+func (h *Handler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleCreateAPIKey"
+
+	input := &struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	apiKey, key, err := h.Service.CreateAPIKey(ctx, userID, input.Name, input.Scopes)
+	if errors.As(err, &model.ValidationError{}) {
+		writeValidationError(w, err)
+		return
+	} else if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	outputJSON, err := json.Marshal(newAPIKeyOutput(apiKey, key))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(outputJSON)
+}
+
+// HandleGetAPIKeys returns all API keys of the user including the revoked ones
+func (h *Handler) HandleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleGetAPIKeys"
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	apiKeys, err := h.Service.GetAPIKeys(ctx, userID)
+	if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(apiKeys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	outputList := make([]apiKeyOutput, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		outputList = append(outputList, newAPIKeyOutput(apiKey, ""))
+	}
+
+	outputJSON, err := json.Marshal(outputList)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(outputJSON)
+}
+
+// HandleRevokeAPIKey revokes the user's API key
+func (h *Handler) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	const nameFunc = "HandleRevokeAPIKey"
+	keyID := chi.URLParam(r, "keyID")
+	userID := h.Cm.GetUserID(r)
+
+	ctx := context.Background()
+	err := h.Service.RevokeAPIKey(ctx, userID, keyID)
+	if errors.As(err, &model.APIKeyNotFoundError{}) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("%s error: %s", nameFunc, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // HandleGetStats returns the number of shortened urls and the number of users in the service
 func (h *Handler) HandleGetStats(w http.ResponseWriter, _ *http.Request) {
 	const nameFunc = "HandleGetStats"
@@ -331,3 +740,19 @@ func (h *Handler) HandleGetStats(w http.ResponseWriter, _ *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// writeValidationError responds 400 with the field and the reason of the validation error as JSON
+func writeValidationError(w http.ResponseWriter, err error) {
+	var validationErr model.ValidationError
+	errors.As(err, &validationErr)
+
+	outputJSON, err := json.Marshal(validationErr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(outputJSON)
+}
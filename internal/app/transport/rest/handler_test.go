@@ -10,22 +10,29 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/kotche/url-shortening-service/internal/app/config"
 	"github.com/kotche/url-shortening-service/internal/app/model"
 	"github.com/kotche/url-shortening-service/internal/app/service"
 	mockService "github.com/kotche/url-shortening-service/internal/app/service/mock"
+	"github.com/kotche/url-shortening-service/internal/app/storage"
 	mockStorage "github.com/kotche/url-shortening-service/internal/app/storage/mock"
 	"github.com/kotche/url-shortening-service/internal/app/storage/test"
 	mockHandler "github.com/kotche/url-shortening-service/internal/app/transport/mock"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandlerHandleGet(t *testing.T) {
 
 	conf, _ := config.NewConfig()
 
+	expired := time.Now().Add(-time.Hour)
+	notExpired := time.Now().Add(time.Hour)
+
 	type want struct {
 		status   int
 		location string
@@ -81,6 +88,30 @@ func TestHandlerHandleGet(t *testing.T) {
 				location: "",
 			},
 		},
+		{
+			name: "url_expired",
+			fields: fields{
+				URL:      &model.URL{Origin: "www.yandex.ru", Short: "qwertyT", ExpiresAt: &expired},
+				endpoint: "/qwertyT",
+				id:       "qwertyT",
+			},
+			want: want{
+				status:   http.StatusGone,
+				location: "",
+			},
+		},
+		{
+			name: "url_not_expired",
+			fields: fields{
+				URL:      &model.URL{Origin: "www.yandex.ru", Short: "qwertyT", ExpiresAt: &notExpired},
+				endpoint: "/qwertyT",
+				id:       "qwertyT",
+			},
+			want: want{
+				status:   http.StatusTemporaryRedirect,
+				location: "www.yandex.ru",
+			},
+		},
 	}
 
 	t.Parallel()
@@ -130,7 +161,6 @@ func TestHandlerHandlePost(t *testing.T) {
 		short  string
 		origin string
 		URLAdd *model.URL
-		URLGet *model.URL
 		err    error
 	}
 
@@ -142,10 +172,9 @@ func TestHandlerHandlePost(t *testing.T) {
 		{
 			name: "new_url",
 			fields: fields{
-				URLAdd: &model.URL{Origin: "www.yandex.ru", Short: "qwertyT"},
-				URLGet: nil,
+				URLAdd: &model.URL{Origin: "https://www.yandex.ru", Short: "qwertyT"},
 				short:  "qwertyT",
-				origin: "www.yandex.ru",
+				origin: "https://www.yandex.ru",
 				userID: "123",
 			},
 			want: want{
@@ -156,11 +185,10 @@ func TestHandlerHandlePost(t *testing.T) {
 		{
 			name: "conflict_url",
 			fields: fields{
-				URLAdd: &model.URL{Origin: "www.yandex.ru", Short: "qwertyT"},
-				URLGet: nil,
+				URLAdd: &model.URL{Origin: "https://www.yandex.ru", Short: "qwertyT"},
 				err:    model.ConflictURLError{ShortenURL: "qwertyT"},
 				short:  "qwertyT",
-				origin: "www.yandex.ru",
+				origin: "https://www.yandex.ru",
 				userID: "123",
 			},
 			want: want{
@@ -182,7 +210,7 @@ func TestHandlerHandlePost(t *testing.T) {
 
 			repo := mockStorage.NewMockStorage(control)
 			repo.EXPECT().Add(ctx, tt.fields.userID, tt.fields.URLAdd).Return(tt.fields.err).Times(1)
-			repo.EXPECT().GetByID(ctx, tt.fields.short).Return(tt.fields.URLGet, tt.fields.err).Times(1)
+			repo.EXPECT().GetByID(gomock.Any(), gomock.Any()).Times(0)
 
 			generator := mockService.Generator{Short: tt.fields.short}
 			cm := mockHandler.CookieManager{Cookie: tt.fields.userID}
@@ -228,6 +256,53 @@ func TestHandlerHandlePostEmptyBody(t *testing.T) {
 
 }
 
+func TestHandlerHandlePostInvalidURL(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	st := storage.NewUrls()
+	s := service.NewService(st)
+	h := NewHandler(s, conf)
+
+	tests := []struct {
+		name string
+		body string
+		want model.ValidationError
+	}{
+		{
+			name: "whitespace",
+			body: "   ",
+			want: model.ValidationError{Field: "url", Reason: "must not be empty"},
+		},
+		{
+			name: "javascript",
+			body: "javascript:alert(1)",
+			want: model.ValidationError{Field: "url", Reason: `scheme "javascript" is not allowed`},
+		},
+		{
+			name: "relative_path",
+			body: "/some/path",
+			want: model.ValidationError{Field: "url", Reason: "must be absolute, e.g. https://example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			h.Router.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var got model.ValidationError
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestHandlerHandlePostJSON(t *testing.T) {
 
 	conf, _ := config.NewConfig()
@@ -242,7 +317,6 @@ func TestHandlerHandlePostJSON(t *testing.T) {
 		short       string
 		origin      string
 		URLAdd      *model.URL
-		URLGet      *model.URL
 		body        string
 		err         error
 		contentType string
@@ -256,11 +330,10 @@ func TestHandlerHandlePostJSON(t *testing.T) {
 		{
 			name: "new_url_correct",
 			fields: fields{
-				body:        `{"url":"www.google.com"}`,
-				origin:      "www.google.com",
+				body:        `{"url":"https://www.google.com"}`,
+				origin:      "https://www.google.com",
 				short:       "qwertyT",
-				URLAdd:      &model.URL{Origin: "www.google.com", Short: "qwertyT"},
-				URLGet:      nil,
+				URLAdd:      &model.URL{Origin: "https://www.google.com", Short: "qwertyT"},
 				userID:      "123",
 				contentType: "application/json",
 			},
@@ -272,11 +345,10 @@ func TestHandlerHandlePostJSON(t *testing.T) {
 		{
 			name: "conflict_url",
 			fields: fields{
-				body:        `{"url":"www.google.com"}`,
-				origin:      "www.google.com",
+				body:        `{"url":"https://www.google.com"}`,
+				origin:      "https://www.google.com",
 				short:       "qwertyT",
-				URLAdd:      &model.URL{Origin: "www.google.com", Short: "qwertyT"},
-				URLGet:      nil,
+				URLAdd:      &model.URL{Origin: "https://www.google.com", Short: "qwertyT"},
 				err:         model.ConflictURLError{ShortenURL: "qwertyT"},
 				userID:      "123",
 				contentType: "application/json",
@@ -300,7 +372,7 @@ func TestHandlerHandlePostJSON(t *testing.T) {
 
 			repo := mockStorage.NewMockStorage(control)
 			repo.EXPECT().Add(ctx, tt.fields.userID, tt.fields.URLAdd).Return(tt.fields.err).Times(1)
-			repo.EXPECT().GetByID(ctx, tt.fields.short).Return(tt.fields.URLGet, tt.fields.err).Times(1)
+			repo.EXPECT().GetByID(gomock.Any(), gomock.Any()).Times(0)
 
 			generator := mockService.Generator{Short: tt.fields.short}
 			cm := mockHandler.CookieManager{Cookie: tt.fields.userID}
@@ -411,6 +483,131 @@ func TestHandlerHandlePostJSONBadRequest(t *testing.T) {
 	}
 }
 
+func TestHandlerHandlePostJSONAlias(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	type want struct {
+		code int
+		body string
+	}
+
+	type fields struct {
+		body     string
+		alias    string
+		URLAdd   *model.URL
+		URLGet   *model.URL
+		errGet   error
+		addTimes int
+		getTimes int
+	}
+
+	tests := []struct {
+		name   string
+		fields fields
+		want   want
+	}{
+		{
+			name: "new_alias",
+			fields: fields{
+				body:     `{"url":"https://www.google.com","alias":"q3-report"}`,
+				alias:    "q3-report",
+				URLAdd:   &model.URL{Origin: "https://www.google.com", Short: "q3-report"},
+				errGet:   errors.New("key not found"),
+				addTimes: 1,
+				getTimes: 1,
+			},
+			want: want{
+				code: http.StatusCreated,
+				body: `{"result":"http://localhost:8080/q3-report"}`,
+			},
+		},
+		{
+			name: "alias_taken",
+			fields: fields{
+				body:     `{"url":"https://www.google.com","alias":"q3-report"}`,
+				alias:    "q3-report",
+				URLGet:   &model.URL{Origin: "www.yandex.ru", Short: "q3-report"},
+				getTimes: 1,
+			},
+			want: want{
+				code: http.StatusConflict,
+				body: "alias q3-report is already taken",
+			},
+		},
+		{
+			name: "alias_deleted",
+			fields: fields{
+				body:     `{"url":"https://www.google.com","alias":"q3-report"}`,
+				alias:    "q3-report",
+				errGet:   model.GoneError{ShortenURL: "www.yandex.ru"},
+				getTimes: 1,
+			},
+			want: want{
+				code: http.StatusConflict,
+				body: "alias q3-report is already taken",
+			},
+		},
+		{
+			name: "alias_reserved",
+			fields: fields{
+				body:  `{"url":"https://www.google.com","alias":"api"}`,
+				alias: "api",
+			},
+			want: want{
+				code: http.StatusBadRequest,
+				body: `{"field":"alias","reason":"\"api\" is a reserved word"}`,
+			},
+		},
+		{
+			name: "alias_wrong_symbols",
+			fields: fields{
+				body:  `{"url":"https://www.google.com","alias":"q3/report"}`,
+				alias: "q3/report",
+			},
+			want: want{
+				code: http.StatusBadRequest,
+				body: `{"field":"alias","reason":"symbol '/' is not allowed"}`,
+			},
+		},
+	}
+
+	t.Parallel()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			control := gomock.NewController(t)
+			defer control.Finish()
+
+			ctx := context.Background()
+
+			repo := mockStorage.NewMockStorage(control)
+			repo.EXPECT().GetByID(ctx, tt.fields.alias).Return(tt.fields.URLGet, tt.fields.errGet).Times(tt.fields.getTimes)
+			repo.EXPECT().Add(ctx, "123", tt.fields.URLAdd).Return(nil).Times(tt.fields.addTimes)
+
+			s := service.NewService(repo)
+			s.Gen = nil
+			h := NewHandler(s, conf)
+			h.Cm = mockHandler.CookieManager{Cookie: "123"}
+
+			body := bytes.NewBufferString(tt.fields.body)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/shorten", body)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.Router.ServeHTTP(w, r)
+
+			response := w.Result()
+			defer response.Body.Close()
+
+			assert.Equal(t, tt.want.code, response.StatusCode)
+			assert.Equal(t, tt.want.body, strings.Trim(w.Body.String(), "\n"))
+		})
+	}
+}
+
 func TestHandlerHandleGetURLs(t *testing.T) {
 
 	conf, _ := config.NewConfig()
@@ -514,15 +711,15 @@ func TestHandlePostShortenBatch(t *testing.T) {
 	input := []model.InputCorrelationURL{
 		{
 			CorrelationID: "1",
-			Origin:        "www.1.ru",
+			Origin:        "https://www.1.ru",
 		},
 		{
 			CorrelationID: "2",
-			Origin:        "www.2.ru",
+			Origin:        "https://www.2.ru",
 		},
 		{
 			CorrelationID: "3",
-			Origin:        "www.3.ru",
+			Origin:        "https://www.3.ru",
 		},
 	}
 
@@ -568,6 +765,7 @@ func TestHandleGetStats(t *testing.T) {
 
 	cfg, _ := config.NewConfig()
 	cfg.TrustedSubnet = "192.168.1.0"
+	cfg.TrustedProxies = "192.0.2.1"
 
 	type want struct {
 		status int
@@ -640,3 +838,445 @@ func TestHandleGetStats(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleGetURLStats(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	type want struct {
+		status int
+		stats  model.ClickStats
+	}
+
+	type fields struct {
+		stats *model.ClickStats
+		err   error
+	}
+
+	tests := []struct {
+		name   string
+		fields fields
+		want   want
+	}{
+		{
+			name: "correct_get_url_stats",
+			fields: fields{
+				stats: &model.ClickStats{
+					TotalClicks:    3,
+					UniqueVisitors: 2,
+					Days:           []model.DayClicks{{Date: "2022-10-01", Clicks: 1}, {Date: "2022-10-02", Clicks: 2}},
+				},
+			},
+			want: want{
+				status: http.StatusOK,
+				stats: model.ClickStats{
+					TotalClicks:    3,
+					UniqueVisitors: 2,
+					Days:           []model.DayClicks{{Date: "2022-10-01", Clicks: 1}, {Date: "2022-10-02", Clicks: 2}},
+				},
+			},
+		},
+		{
+			name: "another_user_url",
+			fields: fields{
+				err: model.NotFoundError{ShortenURL: "qwertyT"},
+			},
+			want: want{
+				status: http.StatusNotFound,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			control := gomock.NewController(t)
+			defer control.Finish()
+
+			ctx := context.Background()
+
+			repo := mockStorage.NewMockDatabase(control)
+			repo.EXPECT().GetClickStats(ctx, "123", "qwertyT").Return(tt.fields.stats, tt.fields.err).Times(1)
+
+			s := service.NewService(repo)
+			s.SetDB(repo)
+
+			h := NewHandler(s, conf)
+			h.Cm = mockHandler.CookieManager{Cookie: "123"}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/user/urls/qwertyT/stats", nil)
+			w := httptest.NewRecorder()
+
+			h.Router.ServeHTTP(w, r)
+
+			response := w.Result()
+			defer response.Body.Close()
+
+			body, _ := io.ReadAll(response.Body)
+
+			var stats model.ClickStats
+			_ = json.Unmarshal(body, &stats)
+
+			assert.Equal(t, tt.want.status, response.StatusCode)
+			assert.Equal(t, tt.want.stats, stats)
+		})
+	}
+}
+
+func TestHandleUpdateURL(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	type want struct {
+		status int
+		body   string
+	}
+
+	type fields struct {
+		body        string
+		URLUpdate   *model.URL
+		err         error
+		updateTimes int
+	}
+
+	tests := []struct {
+		name   string
+		fields fields
+		want   want
+	}{
+		{
+			name: "correct_update",
+			fields: fields{
+				body:        `{"url":"https://www.google.com"}`,
+				URLUpdate:   &model.URL{Origin: "https://www.google.com", Short: "qwertyT"},
+				updateTimes: 1,
+			},
+			want: want{
+				status: http.StatusOK,
+				body:   `{"short_url":"http://localhost:8080/qwertyT","original_url":"https://www.google.com"}`,
+			},
+		},
+		{
+			name: "another_user_url",
+			fields: fields{
+				body:        `{"url":"https://www.google.com"}`,
+				URLUpdate:   &model.URL{Origin: "https://www.google.com", Short: "qwertyT"},
+				err:         model.NotFoundError{ShortenURL: "qwertyT"},
+				updateTimes: 1,
+			},
+			want: want{
+				status: http.StatusNotFound,
+				body:   "url qwertyT not found",
+			},
+		},
+		{
+			name: "empty_url",
+			fields: fields{
+				body: `{"url":""}`,
+			},
+			want: want{
+				status: http.StatusBadRequest,
+				body:   `{"field":"url","reason":"must not be empty"}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			control := gomock.NewController(t)
+			defer control.Finish()
+
+			ctx := context.Background()
+
+			repo := mockStorage.NewMockStorage(control)
+			repo.EXPECT().Update(ctx, "123", tt.fields.URLUpdate).Return(tt.fields.err).Times(tt.fields.updateTimes)
+
+			s := service.NewService(repo)
+			h := NewHandler(s, conf)
+			h.Cm = mockHandler.CookieManager{Cookie: "123"}
+
+			body := bytes.NewBufferString(tt.fields.body)
+
+			r := httptest.NewRequest(http.MethodPatch, "/api/user/urls/qwertyT", body)
+			w := httptest.NewRecorder()
+
+			h.Router.ServeHTTP(w, r)
+
+			response := w.Result()
+			defer response.Body.Close()
+
+			assert.Equal(t, tt.want.status, response.StatusCode)
+			assert.Equal(t, tt.want.body, strings.Trim(w.Body.String(), "\n"))
+		})
+	}
+}
+
+func TestHandleAPIKeys(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	st := storage.NewUrls()
+	s := service.NewService(st)
+	s.SetDB(st)
+	h := NewHandler(s, conf)
+
+	var session *http.Cookie
+
+	do := func(method, target, body, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		} else if session != nil {
+			r.AddCookie(session)
+		}
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, r)
+		if cookies := w.Result().Cookies(); len(cookies) > 0 && apiKey == "" {
+			session = cookies[0]
+		}
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/user/keys", `{"name":"ci","scopes":["admin"]}`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(http.MethodPost, "/api/user/keys", `{"name":"ci","scopes":["shorten","read"]}`, "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	created := struct {
+		ID     string   `json:"id"`
+		Key    string   `json:"key"`
+		Scopes []string `json:"scopes"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Key, config.APIKeyPrefix))
+	assert.Equal(t, []string{"shorten", "read"}, created.Scopes)
+
+	w = do(http.MethodPost, "/api/shorten", `{"url":"https://example.com"}`, created.Key)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Result().Cookies(), "API key clients do not get the cookie")
+
+	w = do(http.MethodGet, "/api/user/urls", "", created.Key)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://example.com")
+
+	w = do(http.MethodGet, "/api/user/urls/abc/stats", "", created.Key)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "api key has no stats scope", strings.Trim(w.Body.String(), "\n"))
+
+	w = do(http.MethodGet, "/api/user/keys", "", created.Key)
+	assert.Equal(t, http.StatusForbidden, w.Code, "API keys cannot manage the keys")
+
+	w = do(http.MethodGet, "/api/user/keys", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), created.ID)
+	assert.NotContains(t, w.Body.String(), created.Key)
+
+	w = do(http.MethodDelete, "/api/user/keys/unknown", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodDelete, "/api/user/keys/"+created.ID, "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(http.MethodPost, "/api/shorten", `{"url":"https://example.org"}`, created.Key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandleAccounts(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	st := storage.NewUrls()
+	s := service.NewService(st)
+	s.SetDB(st)
+	h := NewHandler(s, conf)
+
+	do := func(method, target, body string, session *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json")
+		if session != nil {
+			r.AddCookie(session)
+		}
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, r)
+		cookies := w.Result().Cookies()
+		if len(cookies) > 0 {
+			session = cookies[len(cookies)-1]
+		}
+		return w, session
+	}
+
+	userURLs := func(session *http.Cookie) string {
+		w, _ := do(http.MethodGet, "/api/user/urls", "", session)
+		return w.Body.String()
+	}
+
+	w, first := do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/1"}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	firstUserID := utils.GetUserIDFromCookie(first.Value)
+
+	w, _ = do(http.MethodPost, "/api/user/register", `{"username":"alice","password":"short"}`, first)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, account := do(http.MethodPost, "/api/user/register", `{"username":"Alice","password":"secret-password"}`, first)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	session := struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	assert.Equal(t, firstUserID, session.UserID, "the account keeps the anonymous user ID")
+	assert.Equal(t, "alice", session.Username)
+	assert.Equal(t, session.Token, account.Value)
+	assert.Contains(t, userURLs(account), "https://example.com/1")
+
+	w, _ = do(http.MethodPost, "/api/user/register", `{"username":"alice","password":"another-password"}`, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, second := do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/1"}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	_, second = do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/2"}`, second)
+
+	w, _ = do(http.MethodPost, "/api/user/login", `{"username":"alice","password":"wrong-password"}`, second)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = do(http.MethodPost, "/api/user/login", `{"username":"bob","password":"wrong-password"}`, second)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, account = do(http.MethodPost, "/api/user/login", `{"username":"alice","password":"secret-password"}`, second)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, firstUserID, utils.GetUserIDFromCookie(account.Value))
+
+	urls := make([]map[string]string, 0)
+	assert.NoError(t, json.Unmarshal([]byte(userURLs(account)), &urls))
+	assert.Len(t, urls, 2, "the new link is merged, the duplicate stays with the anonymous user")
+}
+
+func TestHandleQuota(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	st := storage.NewUrls()
+	s := service.NewService(st)
+	s.SetDB(st)
+	s.Quotas = model.Quotas{Default: 2}
+	h := NewHandler(s, conf)
+
+	var session *http.Cookie
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json")
+		if session != nil {
+			r.AddCookie(session)
+		}
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, r)
+		if cookies := w.Result().Cookies(); len(cookies) > 0 {
+			session = cookies[0]
+		}
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/1"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = do(http.MethodGet, "/api/user/quota", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"used":1,"limit":2}`, w.Body.String())
+
+	w = do(http.MethodPost, "/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://example.com/2"},{"correlation_id":"2","original_url":"https://example.com/3"}]`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do(http.MethodGet, "/api/user/quota", "")
+	assert.JSONEq(t, `{"used":1,"limit":2}`, w.Body.String(), "the batch is rejected as a whole")
+
+	w = do(http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"https://example.com/2"}]`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = do(http.MethodPost, "/", "https://example.com/3")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do(http.MethodPost, "/api/shorten", `{"url":"https://example.com/3"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	userID := utils.GetUserIDFromCookie(session.Value)
+	s.Quotas.Users = map[string]int{userID: 3}
+
+	w = do(http.MethodPost, "/", "https://example.com/3")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = do(http.MethodGet, "/api/user/quota", "")
+	assert.JSONEq(t, `{"used":3,"limit":3}`, w.Body.String())
+}
+
+// blockedDomains is the domain policy that blocks the listed hosts
+type blockedDomains map[string]bool
+
+func (b blockedDomains) IsBlocked(host string) bool {
+	return b[host]
+}
+
+func TestHandleBlockedDomain(t *testing.T) {
+
+	conf, _ := config.NewConfig()
+
+	st := storage.NewUrls()
+	s := service.NewService(st)
+	h := NewHandler(s, conf)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/", "https://phish.com/login")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	shortURL := strings.TrimPrefix(w.Body.String(), conf.BaseURL)
+
+	s.Policy = blockedDomains{"phish.com": true}
+
+	w = do(http.MethodGet, shortURL, "")
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	w = do(http.MethodPost, "/", "https://PHISH.com/login")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "domain phish.com is blocked", strings.Trim(w.Body.String(), "\n"))
+
+	w = do(http.MethodPost, "/", "https://example.com")
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestHandlerMakeClick(t *testing.T) {
+	conf, _ := config.NewConfig()
+	h := NewHandler(nil, conf)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		ip         string
+	}{
+		{name: "known_client", remoteAddr: "192.168.1.10:1234", ip: "192.168.1.10"},
+		{name: "unknown_client", remoteAddr: "", ip: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/qwerty", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("Referer", "http://example.com")
+
+			click := h.makeClick(r, "qwerty")
+			assert.Equal(t, "qwerty", click.Short)
+			assert.Equal(t, "http://example.com", click.Referrer)
+			assert.Equal(t, tt.ip, click.IP)
+		})
+	}
+}
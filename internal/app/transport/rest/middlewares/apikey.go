@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// APIKeyResolver returns the active API key by its value
+type APIKeyResolver interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*model.APIKey, error)
+}
+
+// APIKeyHandler authenticates the machine client by the X-API-Key header as the owner of the key.
+// Requests without the header are passed on to the other authentications, unknown and revoked keys are rejected
+func APIKeyHandler(resolver APIKeyResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(apiKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey, err := resolver.AuthenticateAPIKey(r.Context(), key)
+			if errors.As(err, &model.InvalidAPIKeyError{}) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				log.Printf("middlewares APIKeyHandler: %s", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), config.UserIDCookieName, apiKey.UserID)
+			ctx = context.WithValue(ctx, config.APIKeyContext, apiKey)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope rejects the requests authenticated by an API key without the scope.
+// Requests of the users authenticated otherwise are not restricted
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey, ok := r.Context().Value(config.APIKeyContext).(*model.APIKey); ok && !apiKey.HasScope(scope) {
+				http.Error(w, model.ScopeError{Scope: scope}.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DenyAPIKey rejects the requests authenticated by an API key, e.g. the keys cannot manage the keys
+func DenyAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(config.APIKeyContext).(*model.APIKey); ok {
+			http.Error(w, "Not available with the API key", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+)
+
+// BearerHandler authenticates the user by the "Authorization: Bearer <token>" header.
+// Requests without the token are passed on to the cookie authentication, invalid tokens are rejected.
+// Requests already authenticated by the API key are passed as is
+func BearerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		_, authenticated := r.Context().Value(config.UserIDCookieName).(string)
+		if authorization == "" || authenticated {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := utils.GetUserIDFromBearer(authorization)
+		if errors.Is(err, utils.ErrNoBearer) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("middlewares BearerHandler: %s", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), config.UserIDCookieName, userID)))
+	})
+}
@@ -2,44 +2,46 @@ package middlewares
 
 import (
 	"log"
-	"net"
 	"net/http"
-	"strings"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
 )
 
 const accessProhibited = "Access to the internal network is prohibited"
 
 type TrustedNetwork struct {
-	TrustedSubnet net.IP
+	TrustedSubnets utils.Subnets
+	TrustedProxies utils.Subnets
 }
 
+// NewTrustedNetwork parses the trusted subnets and proxies. Invalid settings are logged and deny all access
 func NewTrustedNetwork(cfg *config.Config) *TrustedNetwork {
-	trustedSubnet := net.ParseIP(cfg.TrustedSubnet)
+	trustedSubnets, err := utils.ParseSubnets(cfg.TrustedSubnet)
+	if err != nil {
+		log.Printf("middlewares NewTrustedNetwork: trusted subnet: %s", err)
+	}
+	trustedProxies, err := utils.ParseSubnets(cfg.TrustedProxies)
+	if err != nil {
+		log.Printf("middlewares NewTrustedNetwork: trusted proxies: %s", err)
+	}
 	return &TrustedNetwork{
-		TrustedSubnet: trustedSubnet,
+		TrustedSubnets: trustedSubnets,
+		TrustedProxies: trustedProxies,
 	}
 }
 
-// TrustedNetworkHandler checks whether the client's IP address is included in the trusted subnet
+// TrustedNetworkHandler checks whether the client's IP address is included in the trusted subnets
 func (t *TrustedNetwork) TrustedNetworkHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if t.TrustedSubnet == nil {
+		if len(t.TrustedSubnets) == 0 {
 			log.Print("middlewares TrustedNetworkHandler: empty TrustedSubnet")
 			http.Error(w, accessProhibited, http.StatusForbidden)
 			return
 		}
-		ipStr := r.Header.Get("X-Real-IP")
-		ip := net.ParseIP(ipStr)
-		if ip == nil {
-			ipStr = r.Header.Get("X-Forwarded-For")
-			ipStrs := strings.Split(ipStr, ",")
-			ipStr = ipStrs[0]
-			ip = net.ParseIP(ipStr)
-		}
-		if !t.TrustedSubnet.Equal(ip) {
-			log.Printf("middlewares TrustedNetworkHandler: TrustedSubnet - %s, ip - %s", t.TrustedSubnet, ip)
+		ip := utils.GetClientIP(r, t.TrustedProxies)
+		if !t.TrustedSubnets.Contains(ip) {
+			log.Printf("middlewares TrustedNetworkHandler: TrustedSubnet - %s, ip - %s", t.TrustedSubnets, ip)
 			http.Error(w, accessProhibited, http.StatusForbidden)
 			return
 		}
@@ -2,13 +2,24 @@ package middlewares
 
 import (
 	"context"
+	"log"
 	"net/http"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
 	"github.com/kotche/url-shortening-service/internal/app/utils"
 )
 
-// UserCookieHandler checks for the presence of the user ID in the cookie file. If not, then a new one is issued
+// newUserKey marks the requests with the user ID issued by UserCookieHandler
+type newUserKey struct{}
+
+// IsNewUser reports whether the user ID of the request is just issued, i.e. the client sent no valid cookie
+func IsNewUser(r *http.Request) bool {
+	newUser, _ := r.Context().Value(newUserKey{}).(bool)
+	return newUser
+}
+
+// UserCookieHandler checks for the presence of the user ID in the cookie file. If not, then a new one is issued.
+// Requests already authenticated by the bearer token are passed as is
 func UserCookieHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
@@ -16,6 +27,11 @@ func UserCookieHandler(next http.Handler) http.Handler {
 			userIDCookie string
 		)
 
+		if _, ok := r.Context().Value(config.UserIDCookieName).(string); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		cookieID := utils.GetCookieParam(r, string(config.UserIDCookieName))
 		if cookieID != "" {
 			userID = utils.GetUserIDFromCookie(cookieID)
@@ -25,9 +41,15 @@ func UserCookieHandler(next http.Handler) http.Handler {
 			}
 		}
 
-		userID, userIDCookie = utils.MakeUserIDCookie()
+		userID, userIDCookie, err := utils.MakeUserIDCookie()
+		if err != nil {
+			log.Printf("UserCookieHandler: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		cookie := http.Cookie{Name: string(config.UserIDCookieName), Value: userIDCookie, Path: "/", MaxAge: config.CookieMaxAge}
 		http.SetCookie(w, &cookie)
-		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), config.UserIDCookieName, userID)))
+		ctx := context.WithValue(r.Context(), config.UserIDCookieName, userID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, newUserKey{}, true)))
 	})
 }
@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/kotche/url-shortening-service/internal/app/ratelimit"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
+)
+
+// RateLimit limits the requests of the users and the client addresses
+type RateLimit struct {
+	Limiter        ratelimit.Limiter
+	TrustedProxies utils.Subnets
+	UserID         func(r *http.Request) string
+}
+
+// RateLimitHandler responds with 429 and Retry-After when the user or the client address exceeds the limit.
+// The callers without a cookie get a new user ID on every request, so they are limited by the client address only
+func (l *RateLimit) RateLimitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ip string
+		if clientIP := utils.GetClientIP(r, l.TrustedProxies); clientIP != nil {
+			ip = clientIP.String()
+		}
+
+		var userID string
+		if !IsNewUser(r) {
+			userID = l.UserID(r)
+		}
+
+		ok, wait := ratelimit.AllowAll(l.Limiter, ratelimit.UserKey(userID), ratelimit.IPKey(ip))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,19 +3,25 @@ package test
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
+	"github.com/kotche/url-shortening-service/internal/app/model"
+	"github.com/kotche/url-shortening-service/internal/app/ratelimit"
 	"github.com/kotche/url-shortening-service/internal/app/service"
 	mockService "github.com/kotche/url-shortening-service/internal/app/service/mock"
 	"github.com/kotche/url-shortening-service/internal/app/storage/test"
 	mockHandler "github.com/kotche/url-shortening-service/internal/app/transport/mock"
 	"github.com/kotche/url-shortening-service/internal/app/transport/rest"
 	"github.com/kotche/url-shortening-service/internal/app/transport/rest/middlewares"
+	"github.com/kotche/url-shortening-service/internal/app/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGzipHandle(t *testing.T) {
@@ -153,6 +159,8 @@ func TestGzipHandle(t *testing.T) {
 
 func TestTrustedNetworkHandler(t *testing.T) {
 
+	const proxy = "192.0.2.1"
+
 	type want struct {
 		code int
 	}
@@ -163,35 +171,101 @@ func TestTrustedNetworkHandler(t *testing.T) {
 	}
 
 	tests := []struct {
-		name          string
-		trustedSubnet string
-		header        header
-		want          want
+		name           string
+		trustedSubnet  string
+		trustedProxies string
+		remoteAddr     string
+		header         header
+		want           want
 	}{
 		{
-			name:          "access_allowed_x_real_ip",
-			trustedSubnet: "192.168.0.1",
-			header:        header{name: "X-Real-IP", value: "192.168.0.1"},
+			name:           "access_allowed_x_real_ip",
+			trustedSubnet:  "192.168.0.1",
+			trustedProxies: proxy,
+			header:         header{name: "X-Real-IP", value: "192.168.0.1"},
 			want: want{
 				code: http.StatusOK,
 			},
 		},
 		{
-			name:          "access_allowed_x_forward_for",
-			trustedSubnet: "192.168.0.1",
-			header:        header{name: "X-Forwarded-For", value: "192.168.0.1"},
+			name:           "access_allowed_x_forward_for",
+			trustedSubnet:  "192.168.0.1",
+			trustedProxies: proxy,
+			header:         header{name: "X-Forwarded-For", value: "192.168.0.1"},
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:           "access_allowed_x_forward_for_chain",
+			trustedSubnet:  "192.168.0.0/24",
+			trustedProxies: proxy + ",10.0.0.0/8",
+			header:         header{name: "X-Forwarded-For", value: "203.0.113.5, 192.168.0.7, 10.1.1.1"},
 			want: want{
 				code: http.StatusOK,
 			},
 		},
 		{
-			name:          "access_denied",
+			name:           "access_denied_forged_x_forward_for",
+			trustedSubnet:  "192.168.0.0/24",
+			trustedProxies: proxy,
+			header:         header{name: "X-Forwarded-For", value: "192.168.0.7, 203.0.113.5"},
+			want: want{
+				code: http.StatusForbidden,
+			},
+		},
+		{
+			name:           "access_denied",
+			trustedSubnet:  "192.168.0.1",
+			trustedProxies: proxy,
+			header:         header{name: "X-Real-IP", value: "192.168.0.2"},
+			want: want{
+				code: http.StatusForbidden,
+			},
+		},
+		{
+			name:           "access_allowed_cidr",
+			trustedSubnet:  "192.168.0.0/24",
+			trustedProxies: proxy,
+			header:         header{name: "X-Real-IP", value: "192.168.0.200"},
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:           "access_allowed_second_subnet",
+			trustedSubnet:  "10.0.0.0/8, 192.168.0.0/24",
+			trustedProxies: proxy,
+			header:         header{name: "X-Real-IP", value: "192.168.0.200"},
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:           "access_allowed_ipv6",
+			trustedSubnet:  "2001:db8::/32",
+			trustedProxies: proxy,
+			header:         header{name: "X-Real-IP", value: "2001:db8::1"},
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:          "access_denied_header_from_untrusted_proxy",
 			trustedSubnet: "192.168.0.1",
-			header:        header{name: "X-Real-IP", value: "192.168.0.2"},
+			header:        header{name: "X-Real-IP", value: "192.168.0.1"},
 			want: want{
 				code: http.StatusForbidden,
 			},
 		},
+		{
+			name:          "access_allowed_remote_addr",
+			trustedSubnet: "192.168.0.0/24",
+			remoteAddr:    "192.168.0.5:4321",
+			want: want{
+				code: http.StatusOK,
+			},
+		},
 		{
 			name:          "trusted_subnet_empty",
 			trustedSubnet: "",
@@ -201,8 +275,17 @@ func TestTrustedNetworkHandler(t *testing.T) {
 			},
 		},
 		{
-			name:          "no_ip_in_headers",
-			trustedSubnet: "192.168.0.1",
+			name:          "trusted_subnet_invalid",
+			trustedSubnet: "192.168.0.0/33",
+			remoteAddr:    "192.168.0.5:4321",
+			want: want{
+				code: http.StatusForbidden,
+			},
+		},
+		{
+			name:           "no_ip_in_headers",
+			trustedSubnet:  "192.168.0.1",
+			trustedProxies: proxy,
 			want: want{
 				code: http.StatusForbidden,
 			},
@@ -214,11 +297,14 @@ func TestTrustedNetworkHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			conf := &config.Config{TrustedSubnet: tt.trustedSubnet}
+			conf := &config.Config{TrustedSubnet: tt.trustedSubnet, TrustedProxies: tt.trustedProxies}
 
 			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
 			req := httptest.NewRequest("GET", "http://testing", nil)
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
 			req.Header.Set(tt.header.name, tt.header.value)
 
 			res := httptest.NewRecorder()
@@ -235,3 +321,211 @@ func TestTrustedNetworkHandler(t *testing.T) {
 		})
 	}
 }
+
+type fakeAuthenticator map[string]string
+
+func (a fakeAuthenticator) Authenticate(token string) (string, error) {
+	if userID, ok := a[token]; ok {
+		return userID, nil
+	}
+	return "", fmt.Errorf("invalid token")
+}
+
+func TestBearerHandler(t *testing.T) {
+
+	utils.SetAuthenticator(fakeAuthenticator{"good": "user1"})
+	t.Cleanup(func() { utils.SetAuthenticator(nil) })
+
+	type want struct {
+		code      int
+		userID    string
+		newCookie bool
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          want
+	}{
+		{
+			name:          "valid_token",
+			authorization: "Bearer good",
+			want: want{
+				code:   http.StatusOK,
+				userID: "user1",
+			},
+		},
+		{
+			name:          "invalid_token",
+			authorization: "Bearer bad",
+			want: want{
+				code: http.StatusUnauthorized,
+			},
+		},
+		{
+			name:          "other_scheme_cookie_fallback",
+			authorization: "Basic dXNlcjpwYXNz",
+			want: want{
+				code:      http.StatusOK,
+				newCookie: true,
+			},
+		},
+		{
+			name: "without_token_cookie_fallback",
+			want: want{
+				code:      http.StatusOK,
+				newCookie: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var userID string
+			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID = r.Context().Value(config.UserIDCookieName).(string)
+			})
+
+			req := httptest.NewRequest("GET", "http://testing", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			res := httptest.NewRecorder()
+			handlerToTest := middlewares.BearerHandler(middlewares.UserCookieHandler(nextHandler))
+			handlerToTest.ServeHTTP(res, req)
+			response := res.Result()
+			defer response.Body.Close()
+
+			assert.Equal(t, tt.want.code, response.StatusCode)
+			assert.Equal(t, tt.want.newCookie, len(response.Cookies()) > 0)
+			if tt.want.userID != "" {
+				assert.Equal(t, tt.want.userID, userID)
+			}
+		})
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+
+	type request struct {
+		userID     string
+		remoteAddr string
+	}
+
+	tests := []struct {
+		name     string
+		requests []request
+		code     int
+	}{
+		{
+			name: "within_burst",
+			requests: []request{
+				{userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{userID: "user1", remoteAddr: "192.168.1.10:5000"},
+			},
+			code: http.StatusOK,
+		},
+		{
+			name: "user_limit_exceeded",
+			requests: []request{
+				{userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{userID: "user1", remoteAddr: "192.168.1.11:5000"},
+				{userID: "user1", remoteAddr: "192.168.1.12:5000"},
+			},
+			code: http.StatusTooManyRequests,
+		},
+		{
+			name: "ip_limit_exceeded",
+			requests: []request{
+				{userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{userID: "user2", remoteAddr: "192.168.1.10:5000"},
+				{userID: "user3", remoteAddr: "192.168.1.10:5000"},
+			},
+			code: http.StatusTooManyRequests,
+		},
+		{
+			name: "other_users_and_ips",
+			requests: []request{
+				{userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{userID: "user1", remoteAddr: "192.168.1.10:5000"},
+				{userID: "user2", remoteAddr: "192.168.1.11:5000"},
+			},
+			code: http.StatusOK,
+		},
+	}
+
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			limit := &middlewares.RateLimit{
+				Limiter: ratelimit.NewMemoryLimiter(ratelimit.Limit{Rate: 0.001, Burst: 2}),
+				UserID: func(r *http.Request) string {
+					return r.Header.Get("X-User")
+				},
+			}
+			handlerToTest := limit.RateLimitHandler(nextHandler)
+
+			var response *http.Response
+			for _, r := range tt.requests {
+				req := httptest.NewRequest("POST", "http://testing", nil)
+				req.RemoteAddr = r.remoteAddr
+				req.Header.Set("X-User", r.userID)
+
+				res := httptest.NewRecorder()
+				handlerToTest.ServeHTTP(res, req)
+				response = res.Result()
+				response.Body.Close()
+			}
+
+			assert.Equal(t, tt.code, response.StatusCode)
+			if tt.code == http.StatusTooManyRequests {
+				assert.NotEmpty(t, response.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
+
+// keysLimiter records the keys and allows all requests
+type keysLimiter struct {
+	keys []string
+}
+
+func (l *keysLimiter) Allow(keys ...string) (bool, time.Duration) {
+	l.keys = append(l.keys, keys...)
+	return true, 0
+}
+
+func TestRateLimitHandlerNewUser(t *testing.T) {
+	limiter := &keysLimiter{}
+	limit := &middlewares.RateLimit{
+		Limiter: limiter,
+		UserID: func(r *http.Request) string {
+			return model.CookieManager{}.GetUserID(r)
+		},
+	}
+	handlerToTest := middlewares.UserCookieHandler(limit.RateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	req := httptest.NewRequest("POST", "http://testing", nil)
+	req.RemoteAddr = "192.168.1.10:5000"
+	res := httptest.NewRecorder()
+	handlerToTest.ServeHTTP(res, req)
+	response := res.Result()
+	response.Body.Close()
+
+	assert.Equal(t, []string{"ip:192.168.1.10"}, limiter.keys, "the caller without a cookie is limited by the address")
+	cookies := response.Cookies()
+	require.Len(t, cookies, 1)
+	userID := utils.GetUserIDFromCookie(cookies[0].Value)
+
+	limiter.keys = nil
+	req = httptest.NewRequest("POST", "http://testing", nil)
+	req.RemoteAddr = "192.168.1.10:5000"
+	req.AddCookie(cookies[0])
+	handlerToTest.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, []string{"user:" + userID, "ip:192.168.1.10"}, limiter.keys)
+}
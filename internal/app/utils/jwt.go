@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	bearerPrefix       = "bearer "
+	jwtAlgorithm       = "HS256"
+	defaultUserIDClaim = "sub"
+)
+
+// ErrNoBearer means that the request is not authenticated with a bearer token
+var ErrNoBearer = errors.New("bearer token not found")
+
+// Authenticator checks the bearer token and returns the user ID from it
+type Authenticator interface {
+	Authenticate(token string) (string, error)
+}
+
+type authenticatorHolder struct {
+	Authenticator
+}
+
+var authenticator atomic.Value
+
+// SetAuthenticator sets the authenticator of the bearer tokens, nil disables the bearer authentication
+func SetAuthenticator(auth Authenticator) {
+	authenticator.Store(authenticatorHolder{auth})
+}
+
+// GetUserIDFromBearer returns the user ID from the Authorization header value "Bearer <token>".
+// ErrNoBearer is returned when there is no bearer token or the bearer authentication is disabled
+func GetUserIDFromBearer(authorization string) (string, error) {
+	if len(authorization) < len(bearerPrefix) || !strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+		return "", ErrNoBearer
+	}
+	holder, _ := authenticator.Load().(authenticatorHolder)
+	if holder.Authenticator == nil {
+		return "", ErrNoBearer
+	}
+	return holder.Authenticate(strings.TrimSpace(authorization[len(bearerPrefix):]))
+}
+
+// JWTAuthenticator validates HS256 signed JWT
+type JWTAuthenticator struct {
+	keys        map[string][]byte
+	userIDClaim string
+	issuer      string
+	audience    string
+}
+
+// NewJWTAuthenticator creates the authenticator. The token with the "kid" header is checked with that key only,
+// the token without it is checked with all keys
+func NewJWTAuthenticator(keys map[string][]byte, userIDClaim, issuer, audience string) (*JWTAuthenticator, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwt: no keys")
+	}
+	if err := checkKeys(keys); err != nil {
+		return nil, err
+	}
+	if userIDClaim == "" {
+		userIDClaim = defaultUserIDClaim
+	}
+	return &JWTAuthenticator{
+		keys:        keys,
+		userIDClaim: userIDClaim,
+		issuer:      issuer,
+		audience:    audience,
+	}, nil
+}
+
+// LoadJWTAuthenticator reads the keys from the list and the file, returns nil if no keys are configured
+func LoadJWTAuthenticator(list, fileName, userIDClaim, issuer, audience string) (*JWTAuthenticator, error) {
+	ids, keys, err := loadKeys(list, fileName)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	return NewJWTAuthenticator(keys, userIDClaim, issuer, audience)
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Authenticate checks the signature, the time claims, the issuer and the audience of the token
+func (a *JWTAuthenticator) Authenticate(token string) (string, error) {
+	return a.authenticate(token, time.Now())
+}
+
+func (a *JWTAuthenticator) authenticate(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("jwt: invalid format")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != jwtAlgorithm {
+		return "", fmt.Errorf("jwt: unsupported algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("jwt: signature: %w", err)
+	}
+	if !a.verify(header.Kid, parts[0]+"."+parts[1], signature) {
+		return "", fmt.Errorf("jwt: invalid signature")
+	}
+
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", fmt.Errorf("jwt: exp claim is required")
+	}
+	if now.Add(-allowedClockSkew).After(time.Unix(int64(exp), 0)) {
+		return "", fmt.Errorf("jwt: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(allowedClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return "", fmt.Errorf("jwt: token is not valid yet")
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return "", fmt.Errorf("jwt: invalid issuer")
+	}
+	if a.audience != "" && !hasAudience(claims["aud"], a.audience) {
+		return "", fmt.Errorf("jwt: invalid audience")
+	}
+
+	userID, ok := claims[a.userIDClaim].(string)
+	if !ok || userID == "" {
+		return "", fmt.Errorf("jwt: %s claim is required", a.userIDClaim)
+	}
+	return userID, nil
+}
+
+func (a *JWTAuthenticator) verify(kid, payload string, signature []byte) bool {
+	if kid != "" {
+		key, ok := a.keys[kid]
+		return ok && hmac.Equal(signature, signHS256(key, payload))
+	}
+	for _, key := range a.keys {
+		if hmac.Equal(signature, signHS256(key, payload)) {
+			return true
+		}
+	}
+	return false
+}
+
+func signHS256(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("jwt: %w", err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("jwt: %w", err)
+	}
+	return nil
+}
+
+// hasAudience checks the "aud" claim, that can be a string or an array of strings
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, item := range v {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeJWT(t *testing.T, alg, kid string, key []byte, claims map[string]interface{}) string {
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signHS256(key, unsigned))
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	now := time.Now()
+	key1 := []byte("0123456789abcdef0123")
+	key2 := []byte("fedcba9876543210fedc")
+
+	auth, err := LoadJWTAuthenticator("k1:0123456789abcdef0123,k2:fedcba9876543210fedc", "", "uid", "issuer", "shortener")
+	require.NoError(t, err)
+
+	claims := func(update map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"uid": "user1",
+			"exp": now.Add(time.Hour).Unix(),
+			"iss": "issuer",
+			"aud": []string{"other", "shortener"},
+		}
+		for k, v := range update {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		userID  string
+		wantErr bool
+	}{
+		{
+			name:   "valid_with_kid",
+			token:  makeJWT(t, "HS256", "k2", key2, claims(nil)),
+			userID: "user1",
+		},
+		{
+			name:   "valid_without_kid",
+			token:  makeJWT(t, "HS256", "", key1, claims(map[string]interface{}{"aud": "shortener"})),
+			userID: "user1",
+		},
+		{
+			name:    "wrong_kid",
+			token:   makeJWT(t, "HS256", "k1", key2, claims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "unknown_key",
+			token:   makeJWT(t, "HS256", "", []byte("another-secret-key-123"), claims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "alg_none",
+			token:   makeJWT(t, "none", "k1", key1, claims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			token:   makeJWT(t, "HS256", "k1", key1, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})),
+			wantErr: true,
+		},
+		{
+			name:    "without_exp",
+			token:   makeJWT(t, "HS256", "k1", key1, claims(map[string]interface{}{"exp": nil})),
+			wantErr: true,
+		},
+		{
+			name:    "not_valid_yet",
+			token:   makeJWT(t, "HS256", "k1", key1, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})),
+			wantErr: true,
+		},
+		{
+			name:    "wrong_issuer",
+			token:   makeJWT(t, "HS256", "k1", key1, claims(map[string]interface{}{"iss": "other"})),
+			wantErr: true,
+		},
+		{
+			name:    "wrong_audience",
+			token:   makeJWT(t, "HS256", "k1", key1, claims(map[string]interface{}{"aud": "other"})),
+			wantErr: true,
+		},
+		{
+			name:    "without_user_id",
+			token:   makeJWT(t, "HS256", "k1", key1, claims(map[string]interface{}{"uid": nil, "sub": "user1"})),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "abc.def",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := auth.authenticate(tt.token, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.userID, userID)
+		})
+	}
+}
+
+func TestGetUserIDFromBearer(t *testing.T) {
+	key := []byte("0123456789abcdef0123")
+	token := makeJWT(t, "HS256", "", key, map[string]interface{}{"sub": "user1", "exp": time.Now().Add(time.Hour).Unix()})
+
+	SetAuthenticator(nil)
+	_, err := GetUserIDFromBearer("Bearer " + token)
+	assert.True(t, errors.Is(err, ErrNoBearer), "authentication disabled")
+
+	auth, err := NewJWTAuthenticator(map[string][]byte{"k1": key}, "", "", "")
+	require.NoError(t, err)
+	SetAuthenticator(auth)
+	t.Cleanup(func() { SetAuthenticator(nil) })
+
+	userID, err := GetUserIDFromBearer("bearer " + token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", userID)
+
+	_, err = GetUserIDFromBearer("Basic dXNlcjpwYXNz")
+	assert.True(t, errors.Is(err, ErrNoBearer))
+
+	_, err = GetUserIDFromBearer("Bearer " + token + "x")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNoBearer))
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kotche/url-shortening-service/internal/app/config"
+)
+
+const (
+	minKeyLen          = 16
+	cookieSeparator    = "."
+	allowedClockSkew   = time.Minute
+	keyRingEntryFormat = "<key id>:<secret>"
+)
+
+// KeyRing keeps the signing keys of the user cookies. The current key signs new cookies,
+// all keys verify, so the key can be rotated without logging everyone out
+type KeyRing struct {
+	currentID string
+	keys      map[string][]byte
+	maxAge    time.Duration
+}
+
+const ephemeralKeyID = "ephemeral"
+
+var (
+	keyRing       atomic.Value
+	ephemeralOnce sync.Once
+)
+
+// SetKeyRing sets the key ring used by MakeUserIDCookie and GetUserIDFromCookie
+func SetKeyRing(kr *KeyRing) {
+	keyRing.Store(kr)
+}
+
+// ErrNoSigningKeys is returned if no cookie signing key is configured and no ephemeral one can be generated
+var ErrNoSigningKeys = errors.New("cookie signing keys are not configured, set SECRET_KEYS or SECRET_KEYS_FILE")
+
+// getKeyRing returns the configured key ring. Without SetKeyRing an ephemeral key is generated once:
+// its cookies are invalidated on restart and are not accepted by the other replicas
+func getKeyRing() (*KeyRing, error) {
+	if kr, ok := keyRing.Load().(*KeyRing); ok {
+		return kr, nil
+	}
+	ephemeralOnce.Do(func() {
+		if kr, err := newEphemeralKeyRing(time.Second * config.CookieMaxAge); err == nil {
+			keyRing.CompareAndSwap(nil, kr)
+		}
+	})
+	if kr, ok := keyRing.Load().(*KeyRing); ok {
+		return kr, nil
+	}
+	return nil, ErrNoSigningKeys
+}
+
+// newEphemeralKeyRing creates a key ring with one key read from crypto/rand
+func newEphemeralKeyRing(maxAge time.Duration) (*KeyRing, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSigningKeys, err)
+	}
+	return NewKeyRing(ephemeralKeyID, map[string][]byte{ephemeralKeyID: key}, maxAge)
+}
+
+// NewKeyRing creates a key ring, maxAge limits the cookie age, 0 means unlimited
+func NewKeyRing(currentID string, keys map[string][]byte, maxAge time.Duration) (*KeyRing, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("key ring: current key %q not found", currentID)
+	}
+	if err := checkKeys(keys); err != nil {
+		return nil, err
+	}
+	return &KeyRing{currentID: currentID, keys: keys, maxAge: maxAge}, nil
+}
+
+// LoadKeyRing reads the keys from the comma-separated list and from the file with one key per line.
+// Entry format: <key id>:<secret>. The first key is the current one. At least one key is required
+func LoadKeyRing(list string, fileName string, maxAge time.Duration) (*KeyRing, error) {
+	ids, keys, err := loadKeys(list, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, ErrNoSigningKeys
+	}
+	return NewKeyRing(ids[0], keys, maxAge)
+}
+
+// loadKeys reads the <key id>:<secret> entries from the list and the file, ids keep the order of the entries
+func loadKeys(list string, fileName string) ([]string, map[string][]byte, error) {
+	entries := make([]string, 0)
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	if fileName != "" {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			entry := strings.TrimSpace(scanner.Text())
+			if entry != "" && !strings.HasPrefix(entry, "#") {
+				entries = append(entries, entry)
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ids := make([]string, 0, len(entries))
+	keys := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("keys: entry must be %s", keyRingEntryFormat)
+		}
+		id := strings.TrimSpace(parts[0])
+		if _, ok := keys[id]; ok {
+			return nil, nil, fmt.Errorf("keys: duplicate key id %q", id)
+		}
+		ids = append(ids, id)
+		keys[id] = []byte(strings.TrimSpace(parts[1]))
+	}
+
+	return ids, keys, nil
+}
+
+// checkKeys validates the key ids and the key lengths
+func checkKeys(keys map[string][]byte) error {
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, cookieSeparator) {
+			return fmt.Errorf("keys: invalid key id %q", id)
+		}
+		if len(key) < minKeyLen {
+			return fmt.Errorf("keys: key %q is shorter than %d bytes", id, minKeyLen)
+		}
+	}
+	return nil
+}
+
+// Sign returns the cookie value: <key id>.<user id>.<issued at>.<signature>
+func (kr *KeyRing) Sign(userID string, issuedAt time.Time) string {
+	payload := strings.Join([]string{kr.currentID, userID, strconv.FormatInt(issuedAt.Unix(), 10)}, cookieSeparator)
+	return payload + cookieSeparator + hex.EncodeToString(kr.mac(kr.keys[kr.currentID], payload))
+}
+
+// Verify checks the signature and the age of the cookie and returns the user ID
+func (kr *KeyRing) Verify(cookie string, now time.Time) (string, error) {
+	parts := strings.Split(cookie, cookieSeparator)
+	if len(parts) != 4 {
+		return "", fmt.Errorf("cookie: invalid format")
+	}
+
+	key, ok := kr.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("cookie: unknown key id %q", parts[0])
+	}
+
+	signature, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return "", fmt.Errorf("cookie: %w", err)
+	}
+	payload := strings.Join(parts[:3], cookieSeparator)
+	if !hmac.Equal(signature, kr.mac(key, payload)) {
+		return "", fmt.Errorf("cookie: invalid signature")
+	}
+
+	issuedAtUnix, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("cookie: %w", err)
+	}
+	issuedAt := time.Unix(issuedAtUnix, 0)
+	if issuedAt.After(now.Add(allowedClockSkew)) {
+		return "", fmt.Errorf("cookie: issued in the future")
+	}
+	if kr.maxAge > 0 && now.Sub(issuedAt) > kr.maxAge {
+		return "", fmt.Errorf("cookie: expired")
+	}
+
+	return parts[1], nil
+}
+
+func (kr *KeyRing) mac(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
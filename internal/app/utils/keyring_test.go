@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKeyRing(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "keys")
+	err := os.WriteFile(fileName, []byte("# old keys\nv1:0123456789abcdef0123\n\n"), 0600)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		list     string
+		fileName string
+		current  string
+		wantErr  bool
+	}{
+		{
+			name:    "from_list",
+			list:    "v2:fedcba9876543210fedc, v1:0123456789abcdef0123",
+			current: "v2",
+		},
+		{
+			name:     "list_and_file",
+			list:     "v2:fedcba9876543210fedc",
+			fileName: fileName,
+			current:  "v2",
+		},
+		{
+			name:     "only_file",
+			fileName: fileName,
+			current:  "v1",
+		},
+		{
+			name:    "short_key",
+			list:    "v1:short",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate_key_id",
+			list:    "v1:0123456789abcdef0123,v1:fedcba9876543210fedc",
+			wantErr: true,
+		},
+		{
+			name:    "without_key_id",
+			list:    "0123456789abcdef0123",
+			wantErr: true,
+		},
+		{
+			name:     "file_not_found",
+			fileName: filepath.Join(t.TempDir(), "none"),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr, err := LoadKeyRing(tt.list, tt.fileName, 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.current, kr.currentID)
+		})
+	}
+
+	kr, err := LoadKeyRing("", "", 0)
+	assert.ErrorIs(t, err, ErrNoSigningKeys)
+	assert.Nil(t, kr)
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	now := time.Now()
+
+	oldRing, err := LoadKeyRing("v1:0123456789abcdef0123", "", time.Hour)
+	require.NoError(t, err)
+	newRing, err := LoadKeyRing("v2:fedcba9876543210fedc,v1:0123456789abcdef0123", "", time.Hour)
+	require.NoError(t, err)
+	otherRing, err := LoadKeyRing("v1:another-secret-key-123", "", time.Hour)
+	require.NoError(t, err)
+
+	oldCookie := oldRing.Sign("a1b2c3d4", now)
+	userID, err := newRing.Verify(oldCookie, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "a1b2c3d4", userID)
+
+	newCookie := newRing.Sign("a1b2c3d4", now)
+	assert.Regexp(t, `^v2\.a1b2c3d4\.\d+\.[0-9a-f]{64}$`, newCookie)
+	_, err = oldRing.Verify(newCookie, now)
+	assert.Error(t, err, "unknown key id")
+
+	_, err = otherRing.Verify(oldCookie, now)
+	assert.Error(t, err, "forged signature")
+
+	_, err = newRing.Verify(newRing.Sign("a1b2c3d4", now.Add(-2*time.Hour)), now)
+	assert.Error(t, err, "expired cookie")
+
+	_, err = newRing.Verify(newRing.Sign("a1b2c3d4", now.Add(time.Hour)), now)
+	assert.Error(t, err, "issued in the future")
+
+	_, err = newRing.Verify("v2.a1b2c3d4."+"1.00", now)
+	assert.Error(t, err)
+	_, err = newRing.Verify("a1b2c3d4", now)
+	assert.Error(t, err)
+}
+
+func TestUserIDCookie(t *testing.T) {
+	kr, err := LoadKeyRing("v1:0123456789abcdef0123", "", time.Hour)
+	require.NoError(t, err)
+	SetKeyRing(kr)
+
+	userID, cookie, err := MakeUserIDCookie()
+	require.NoError(t, err)
+	assert.Len(t, userID, 16)
+	assert.Equal(t, userID, GetUserIDFromCookie(cookie))
+	assert.Empty(t, GetUserIDFromCookie(cookie+"0"))
+}
+
+func TestEphemeralKeyRing(t *testing.T) {
+	kr, err := newEphemeralKeyRing(time.Hour)
+	require.NoError(t, err)
+
+	now := time.Now()
+	userID, err := kr.Verify(kr.Sign("a1b2c3d4", now), now)
+	require.NoError(t, err)
+	assert.Equal(t, "a1b2c3d4", userID)
+
+	other, err := newEphemeralKeyRing(time.Hour)
+	require.NoError(t, err)
+	_, err = other.Verify(kr.Sign("a1b2c3d4", now), now)
+	assert.Error(t, err, "the ephemeral keys differ")
+}
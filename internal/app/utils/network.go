@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Subnets is a list of IPv4 and IPv6 networks
+type Subnets []*net.IPNet
+
+// ParseSubnets parses a comma-separated list of CIDRs. A single IP address is treated as a network of one address
+func ParseSubnets(s string) (Subnets, error) {
+	subnets := make(Subnets, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", part)
+			}
+			bits := net.IPv6len * 8
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), net.IPv4len*8
+			}
+			subnets = append(subnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, subnet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// Contains checks whether the IP address belongs to one of the networks
+func (s Subnets) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range s {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveClientIP returns the client address. The X-Real-IP and X-Forwarded-For values are believed only
+// if the direct peer is a trusted proxy. X-Forwarded-For is read from the right, skipping the trusted proxies,
+// because the left part of the list is written by the client and can be forged
+func ResolveClientIP(remoteAddr string, realIP string, forwardedFor string, proxies Subnets) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	remoteIP := net.ParseIP(host)
+
+	if !proxies.Contains(remoteIP) {
+		return remoteIP
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(realIP)); ip != nil {
+		return ip
+	}
+
+	if forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if !proxies.Contains(ip) || i == 0 {
+				return ip
+			}
+		}
+	}
+
+	return remoteIP
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseQuotas parses a comma-separated list of the user quotas: user1:100,user2:0
+func ParseQuotas(s string) (map[string]int, error) {
+	quotas := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		userID, limitStr, ok := strings.Cut(part, ":")
+		userID = strings.TrimSpace(userID)
+		if !ok || userID == "" {
+			return nil, fmt.Errorf("invalid quota %q, expected user:limit", part)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid quota limit %q", part)
+		}
+		quotas[userID] = limit
+	}
+	return quotas, nil
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuotas(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]int{},
+		},
+		{
+			name:  "list",
+			input: "user1:100, user2:0",
+			want:  map[string]int{"user1": 100, "user2": 0},
+		},
+		{
+			name:    "without_limit",
+			input:   "user1",
+			wantErr: true,
+		},
+		{
+			name:    "negative_limit",
+			input:   "user1:-1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid_limit",
+			input:   "user1:many",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quotas, err := ParseQuotas(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, quotas)
+		})
+	}
+}
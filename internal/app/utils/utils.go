@@ -2,12 +2,13 @@ package utils
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
+	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/kotche/url-shortening-service/internal/app/config"
 	"google.golang.org/grpc/metadata"
@@ -22,37 +23,50 @@ func GetCookieParam(r *http.Request, name string) string {
 	return cookieParam.Value
 }
 
-// MakeUserIDCookie generates an encrypted user id for cookies
-func MakeUserIDCookie() (string, string) {
+// GetClientIP returns the IP address of the client taking into account the trusted proxies
+func GetClientIP(r *http.Request, proxies Subnets) net.IP {
+	return ResolveClientIP(r.RemoteAddr, r.Header.Get("X-Real-IP"), r.Header.Get("X-Forwarded-For"), proxies)
+}
+
+// MakeUserIDCookie generates a new user id and the signed cookie value for it. The id is read from crypto/rand,
+// so the ids of the other users can not be guessed
+func MakeUserIDCookie() (string, string, error) {
 	userID := make([]byte, config.UserIDLen)
 
-	rand.Read(userID)
+	if _, err := rand.Read(userID); err != nil {
+		return "", "", fmt.Errorf("user id: %w", err)
+	}
 	encodedID := hex.EncodeToString(userID)
 
-	h := hmac.New(sha256.New, config.GetSecretKey())
-	h.Write(userID)
-	hash := h.Sum(nil)
-	return encodedID, encodedID + hex.EncodeToString(hash)
+	cookie, err := SignUserID(encodedID)
+	if err != nil {
+		return "", "", err
+	}
+	return encodedID, cookie, nil
 }
 
-// GetUserIDFromCookie receives an encrypted user id from the cookie
+// SignUserID returns the cookie value for the user id signed with the current key
+func SignUserID(userID string) (string, error) {
+	kr, err := getKeyRing()
+	if err != nil {
+		return "", err
+	}
+	return kr.Sign(userID, time.Now()), nil
+}
+
+// GetUserIDFromCookie receives the user id from the signed cookie
 func GetUserIDFromCookie(CookieID string) string {
-	data, err := hex.DecodeString(CookieID)
+	kr, err := getKeyRing()
 	if err != nil {
-		log.Println(err.Error())
+		log.Printf("UserID no auth: %s", err)
 		return ""
 	}
-	id := data[:config.UserIDLen]
-	h := hmac.New(sha256.New, config.GetSecretKey())
-	h.Write(id)
-	hash := h.Sum(nil)
-
-	if hmac.Equal(hash, data[config.UserIDLen:]) {
-		return hex.EncodeToString(id)
-	} else {
-		log.Printf("UserID %v no auth", string(id))
+	userID, err := kr.Verify(CookieID, time.Now())
+	if err != nil {
+		log.Printf("UserID no auth: %s", err)
 		return ""
 	}
+	return userID
 }
 
 func GetUserIDFromMD(ctx context.Context) string {